@@ -0,0 +1,152 @@
+package adminz
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Access restricts who may use an admin page, and how.
+type Access struct {
+	// Request methods the page accepts, eg "POST". Any method if empty.
+	Methods []string
+	// Only serve requests from loopback addresses, eg from a sidecar or someone ssh'd in.
+	LoopbackOnly bool
+	// If set, only serve requests it accepts, eg TokenAuth.
+	Auth ControlAuth
+	// Require a confirm=true parameter, so a page can't be triggered by accident, eg by a crawler
+	// or a stray curl.
+	Confirm bool
+}
+
+// Pages that stop the process, which by default must be POSTed with confirm=true.
+var destructive = map[string]bool{
+	"/quitquitquit":    true,
+	"/abortabortabort": true,
+}
+
+var destructiveAccess = Access{Methods: []string{"POST"}, Confirm: true}
+
+// Protect restricts the page at path, replacing any default. Pages from Handle and Page, as well as
+// standard ones, may be protected.
+func (a *Adminz) Protect(path string, access Access) *Adminz {
+	if a.access == nil {
+		a.access = make(map[string]Access)
+	}
+	a.access[path] = access
+	return a
+}
+
+// DefaultAccess restricts every page that isn't given its own Access by Protect. Destructive pages
+// (/quitquitquit and /abortabortabort) additionally always need POST and confirm=true unless
+// given their own Access.
+func (a *Adminz) DefaultAccess(access Access) *Adminz {
+	a.defaultAccess = &access
+	return a
+}
+
+// The access the page at path has, if it is restricted at all.
+func (a *Adminz) accessFor(path string) (Access, bool) {
+	if access, ok := a.access[path]; ok {
+		return access, true
+	}
+	access, restricted := Access{}, false
+	if a.defaultAccess != nil {
+		access, restricted = *a.defaultAccess, true
+	}
+	if destructive[path] {
+		access.Methods, access.Confirm = destructiveAccess.Methods, true
+		restricted = true
+	}
+	return access, restricted
+}
+
+// Wraps h with the access policy for path, if any.
+func (a *Adminz) protect(path string, h http.Handler) http.Handler {
+	access, restricted := a.accessFor(path)
+	if !restricted {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(access.Methods) > 0 && !contains(access.Methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(access.Methods, ", "))
+			http.Error(w, "Must "+strings.Join(access.Methods, " or "), http.StatusMethodNotAllowed)
+			return
+		}
+		if access.LoopbackOnly && !isLoopback(r.RemoteAddr) {
+			http.Error(w, "Only available from localhost", http.StatusForbidden)
+			return
+		}
+		if access.Auth != nil {
+			if _, err := access.Auth(r); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		if access.Confirm {
+			if ok, _ := strconv.ParseBool(r.FormValue("confirm")); !ok {
+				http.Error(w, "Add confirm=true to confirm", http.StatusBadRequest)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func contains(l []string, s string) bool {
+	for _, i := range l {
+		if strings.EqualFold(i, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen serves adminz on its own listener at addr, eg "localhost:8081", rather than on the
+// application's mux, so it can be firewalled or bound to loopback separately. Pages are also
+// mounted on the mux set by ServeMux, if there is one, but not on the default mux.
+func (a *Adminz) Listen(addr string) *Adminz {
+	a.addr = addr
+	return a
+}
+
+// Addr is the address adminz is listening on, when using Listen, eg to find the port chosen for
+// ":0". Empty until Start.
+func (a *Adminz) Addr() string {
+	a.Lock()
+	defer a.Unlock()
+	if a.listener == nil {
+		return ""
+	}
+	return a.listener.Addr().String()
+}
+
+// Starts serving on a.addr.
+func (a *Adminz) listen(handler http.Handler) error {
+	l, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return fmt.Errorf("adminz: unable to listen on %s: %v", a.addr, err)
+	}
+	server := &http.Server{Handler: handler}
+	a.Lock()
+	a.listener, a.server = l, server
+	a.Unlock()
+	logger.Info("adminz listening", "addr", l.Addr())
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("adminz stopped serving", "err", err)
+		}
+	}()
+	return nil
+}
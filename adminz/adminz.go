@@ -5,17 +5,28 @@ package adminz
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"os"
-	"runtime"
 	"sync"
 	"time"
+
+	"github.com/foursquare/fsgo/logging"
 )
 
+var logger = logging.New("adminz")
+
 type Adminz struct {
-	// keep track of killfile state
-	running bool
+	// where the server is in its lifecycle; it is only running while Serving
+	state State
+
+	// run after Start, before serving
+	warmups []func() error
+	// the error the last warm-up failed with, if any
+	warmupErr error
+
+	// called on entering each state
+	hooks map[State][]func(from State)
 
 	// ticker that checks killfiles every 1 second
 	killfileTicker *time.Ticker
@@ -23,6 +34,31 @@ type Adminz struct {
 	// list of killfilePaths to check
 	killfilePaths []string
 
+	// the killfile currently pausing the server, if any
+	killfile *Killfile
+
+	// a pause requested over HTTP, if any
+	hold *hold
+
+	// if set, enables the pause/resume endpoints for requests it accepts
+	controlAuth ControlAuth
+
+	// if set, enables profiling and other debugging pages
+	debug *DebugOptions
+
+	// tunables served on /flagz
+	flags *Flags
+
+	// records pauses and resumes, and who made them
+	audit auditLog
+
+	// notifies of changes to killfile directories; nil if polling instead
+	watcher dirWatcher
+
+	// closed to stop the killfile loop, which then closes loopDone
+	stop     chan struct{}
+	loopDone chan struct{}
+
 	// defaults to 1 second
 	checkInterval time.Duration
 
@@ -42,11 +78,42 @@ type Adminz struct {
 
 	// the various handlers are attached to serveMux or DefaultServeMux
 	mux *http.ServeMux
+
+	// where pages are served, eg "/admin"; empty for the root
+	prefix string
+
+	// serves every page, built once by Handler
+	build   sync.Once
+	handler http.Handler
+
+	// the mux the handler was mounted on by Start, if any
+	mountedOn *http.ServeMux
+
+	// additional pages, eg from other packages, attached along with the standard handlers
+	pages []page
+
+	// every page registered by Start, listed on /adminz
+	registered []page
+
+	// access policies for particular pages, and for the rest
+	access        map[string]Access
+	defaultAccess *Access
+
+	// if set, adminz is served on its own listener at addr
+	addr     string
+	listener net.Listener
+	server   *http.Server
+}
+
+type page struct {
+	path        string
+	description string
+	handler     http.Handler
 }
 
 // Creates a new Adminz "builder". Not safe to use until Start() is called.
 func New() *Adminz {
-	return &Adminz{}
+	return &Adminz{flags: newFlags()}
 }
 
 func (a *Adminz) Resume() {
@@ -55,16 +122,19 @@ func (a *Adminz) Resume() {
 	a.doResume()
 }
 
-// Internal helper to resume if stopped. MUST be called while holding a.Lock. Use a.Resume if not.
+// Internal helper to resume if draining. MUST be called while holding a.Lock. Use a.Resume if not.
+// Before warm-ups complete, or once stopped, this does nothing.
 func (a *Adminz) doResume() {
-	if !a.running {
+	if a.state == Draining {
 		if a.onresume != nil {
 			a.onresume()
 		}
-		a.running = true
+		a.setState(Serving)
 	}
 }
 
+// ServeMux sets the mux Start mounts adminz's pages on, instead of http.DefaultServeMux. Another
+// adminz started on the same mux replaces them, and Stop removes them.
 func (a *Adminz) ServeMux(mux *http.ServeMux) *Adminz {
 	a.mux = mux
 	return a
@@ -85,14 +155,14 @@ func (a *Adminz) Pause() bool {
 
 // Internal helper to pause if running. MUST be called while holding a.Lock. Use a.Pause if not.
 func (a *Adminz) doPause() bool {
-	was := a.running
-	if a.running {
-		if a.onpause != nil {
-			a.onpause()
-		}
-		a.running = false
+	if a.state != Serving {
+		return false
+	}
+	if a.onpause != nil {
+		a.onpause()
 	}
-	return was
+	a.setState(Draining)
+	return true
 }
 
 // pause is called when the server is killed
@@ -108,12 +178,29 @@ func (a *Adminz) Healthy(healthy func() bool) *Adminz {
 }
 
 // servicez generates data to return to /servicez endpoint. marshalled into
-// json.
+// json, under "service" alongside build and runtime info (see ServiceInfo).
 func (a *Adminz) Servicez(servicez func() interface{}) *Adminz {
 	a.servicez = servicez
 	return a
 }
 
+// Handle adds an additional admin page, such as a thriftrpc.CallStats, to be served at path
+// alongside the standard handlers. If handler has an `AdminDescription() string` method, as pages
+// from other fsgo packages do, it is used to describe the page on /adminz.
+func (a *Adminz) Handle(path string, handler http.Handler) *Adminz {
+	description := ""
+	if d, ok := handler.(describer); ok {
+		description = d.AdminDescription()
+	}
+	return a.Page(path, description, handler)
+}
+
+// Page adds an additional admin page, like Handle, with a description for /adminz.
+func (a *Adminz) Page(path, description string, handler http.Handler) *Adminz {
+	a.pages = append(a.pages, page{path, description, handler})
+	return a
+}
+
 // Sets the list of killfilePaths to check.
 func (a *Adminz) KillfilePaths(killfilePaths []string) *Adminz {
 	a.killfilePaths = killfilePaths
@@ -126,26 +213,36 @@ func (a *Adminz) KillfileInterval(interval time.Duration) *Adminz {
 	return a
 }
 
-// Start initializes handlers and starts killfile checking. Make sure to
-// remember to call this!
+// Start initializes handlers, starts killfile checking and runs warm-ups, after which the server
+// is serving, unless paused by a killfile. Make sure to remember to call this! Panics if unable to
+// listen on the address given to Listen, as the admin pages would be unreachable; use StartErr to
+// handle that instead.
 func (a *Adminz) Start() *Adminz {
+	if err := a.StartErr(); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// StartErr is Start, but returns an error if unable to listen on the address given to Listen, in
+// which case nothing is started.
+func (a *Adminz) StartErr() error {
+	if a.addr != "" {
+		if err := a.listen(a.Handler()); err != nil {
+			return err
+		}
+	}
+	a.Lock()
+	a.setState(Warming)
+	a.Unlock()
+
 	if a.mux != nil {
-		a.mux.HandleFunc("/healthz", a.healthzHandler)
-		a.mux.HandleFunc("/health", a.healthzHandler)
-		a.mux.HandleFunc("/servicez", a.ServicezHandler)
-		a.mux.HandleFunc("/quitquitquit", a.quitHandler)
-		a.mux.HandleFunc("/abortabortabort", a.abortHandler)
-		a.mux.HandleFunc("/gc", a.gcHandler)
-	} else {
-		http.HandleFunc("/healthz", a.healthzHandler)
-		http.HandleFunc("/health", a.healthzHandler)
-		http.HandleFunc("/servicez", a.ServicezHandler)
-		http.HandleFunc("/quitquitquit", a.quitHandler)
-		http.HandleFunc("/abortabortabort", a.abortHandler)
-		http.HandleFunc("/gc", a.gcHandler)
+		a.mount(a.mux)
+	} else if a.addr == "" {
+		a.mount(http.DefaultServeMux)
 	}
 
-	log.Print("adminz registered")
+	logger.Info("adminz registered")
 
 	if a.checkInterval == 0 {
 		a.checkInterval = 1 * time.Second
@@ -154,27 +251,63 @@ func (a *Adminz) Start() *Adminz {
 
 	// start killfile checking loop
 	if len(a.killfilePaths) > 0 {
-		log.Print("Watching paths for killfile: ", a.killfilePaths)
+		logger.Info("watching for killfiles", "paths", a.killfilePaths)
 		a.checkKillfiles()
-		go a.killfileLoop()
+		if w, err := newDirWatcher(killfileDirs(a.killfilePaths)); err != nil {
+			logger.Warn("polling for killfiles, unable to watch for changes", "interval", a.checkInterval, "err", err)
+		} else {
+			a.watcher = w
+		}
+		a.Lock()
+		a.stop, a.loopDone = make(chan struct{}), make(chan struct{})
+		go a.killfileLoop(a.stop, a.loopDone)
+		a.Unlock()
 	} else {
-		log.Print("No killfiles being watched.")
+		logger.Info("no killfiles being watched")
 	}
 
-	return a
+	if len(a.warmups) > 0 {
+		go a.warmup()
+	} else {
+		a.warmup()
+	}
+	return nil
 }
 
-// Stops killfile checking
+// Drains, if serving, then stops serving pages, on the mux they were mounted on or on adminz's
+// own listener, and stops killfile checking, waiting for it to finish. Once stopped, the server
+// can't be resumed.
 func (a *Adminz) Stop() {
 	if a.killfileTicker != nil {
 		a.killfileTicker.Stop()
 	}
+	a.Lock()
+	a.clearHold()
+	a.doPause()
+	a.setState(Stopped)
+	stop, loopDone := a.stop, a.loopDone
+	a.stop, a.loopDone = nil, nil
+	server := a.server
+	a.server = nil
+	a.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-loopDone
+	}
+	if a.watcher != nil {
+		a.watcher.Close()
+	}
+	a.unmount()
+	if server != nil {
+		server.Close()
+	}
 }
 
 // Generates the standard set of killfiles. Pass these to KillfilePaths
 func Killfiles(ports ...int) []string {
 	// the number of ports + the "all" killfile
-	log.Print(ports)
+	logger.Debug("generating killfiles", "ports", ports)
 	var ret = make([]string, len(ports)+1)
 	for i, port := range ports {
 		ret[i] = fmt.Sprintf("/dev/shm/healthz/kill.%d", port)
@@ -184,25 +317,85 @@ func Killfiles(ports ...int) []string {
 }
 
 func (a *Adminz) checkKillfiles() bool {
-	for _, killfile := range a.killfilePaths {
-		file, err := os.Open(killfile)
-		if file != nil && err == nil {
-			file.Close()
-			if a.Pause() {
-				log.Println("paused due to ", killfile)
-			} else {
-				//a.Pause() == false because a.running == false
-				log.Println("continue pause due to ", killfile)
-			}
-			return true
+	now := time.Now()
+	for _, path := range a.killfilePaths {
+		k := readKillfile(path)
+		if k == nil || k.expired(now) {
+			continue
+		}
+		a.Lock()
+		prev := a.killfile
+		a.killfile = k
+		paused := a.doPause()
+		a.Unlock()
+
+		if paused {
+			a.audit.add("killfile", "pause", k.String())
+			logger.Warn("paused by killfile", "killfile", k)
+		} else if prev == nil || *prev != *k {
+			//a.doPause() == false because the server isn't serving
+			logger.Info("continuing pause for killfile", "killfile", k)
+		}
+		return true
+	}
+
+	a.Lock()
+	prev := a.killfile
+	a.killfile = nil
+	// a pause requested over HTTP outlasts the killfile.
+	if a.hold == nil {
+		a.doResume()
+	}
+	a.Unlock()
+
+	if prev != nil {
+		a.audit.add("killfile", "resume", prev.String())
+		if prev.expired(now) {
+			logger.Info("resumed as killfile expired", "killfile", prev)
+		} else {
+			logger.Info("resumed as killfile removed", "killfile", prev)
 		}
 	}
-	a.Resume()
 	return false
 }
 
-func (a *Adminz) killfileLoop() {
-	for _ = range a.killfileTicker.C {
+// Re-checks killfiles whenever their directories change, or every checkInterval if they can't
+// be watched, and when the current killfile expires.
+func (a *Adminz) killfileLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	var changes <-chan struct{}
+	var ticks <-chan time.Time
+	if a.watcher != nil {
+		changes = a.watcher.Events()
+	} else {
+		ticks = a.killfileTicker.C
+	}
+
+	for {
+		var expiry *time.Timer
+		var expired <-chan time.Time
+		a.Lock()
+		if a.killfile != nil && !a.killfile.Expires.IsZero() {
+			expiry = time.NewTimer(time.Until(a.killfile.Expires))
+			expired = expiry.C
+		}
+		a.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case _, ok := <-changes:
+			if !ok {
+				// Stop only closes the watcher once this loop has returned, so watching failed.
+				logger.Warn("stopped receiving killfile changes, polling instead", "interval", a.checkInterval)
+				changes, ticks = nil, a.killfileTicker.C
+			}
+		case <-ticks:
+		case <-expired:
+		}
+		if expiry != nil {
+			expiry.Stop()
+		}
 		a.checkKillfiles()
 	}
 }
@@ -214,12 +407,27 @@ func (a *Adminz) healthzHandler(w http.ResponseWriter, r *http.Request) {
 	// a.healthy() returns true
 	var ret string
 
-	if a.running && (a.healthy == nil || a.healthy()) {
+	a.Lock()
+	state, killfile, hold, warmupErr := a.state, a.killfile, a.hold, a.warmupErr
+	a.Unlock()
+
+	w.Header().Set("X-Server-State", state.String())
+	if state == Serving && (a.healthy == nil || a.healthy()) {
 		ret = "OK"
 	} else {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		ret = "Service Unavailable"
-		log.Println("Unhealthy, returning ", ret)
+		switch {
+		case state == Warming && warmupErr != nil:
+			ret += ": warm-up failed: " + warmupErr.Error()
+		case state != Serving && state != Draining:
+			ret += ": " + state.String()
+		case killfile != nil && killfile.describe() != "":
+			ret += ": " + killfile.describe()
+		case killfile == nil && hold != nil:
+			ret += ": " + hold.String()
+		}
+		logger.Debug("unhealthy", "response", ret)
 	}
 
 	w.Write(([]byte)(ret))
@@ -229,19 +437,11 @@ type EmptyStruct struct {
 }
 
 func (a *Adminz) ServicezHandler(w http.ResponseWriter, r *http.Request) {
-	var data interface{}
-
-	if a.servicez != nil {
-		data = a.servicez()
-	} else {
-		data = &EmptyStruct{}
-	}
-
-	bytes, err := json.Marshal(data)
+	// TODO I probably need to serialize reads to servicez as who knows what
+	// people will put in that function
+	bytes, err := json.Marshal(a.serviceInfo())
 	if err == nil {
 		w.Header().Add("Content-Type", "application/json")
-		// TODO I probably need to serialize reads to servicez as who knows what
-		// people will put in that function
 		w.Write(bytes)
 	} else {
 		http.Error(w, err.Error(), 500)
@@ -249,44 +449,12 @@ func (a *Adminz) ServicezHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Adminz) quitHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("quitquitquit called! Pausing and shutting down service")
+	logger.Warn("quitquitquit called, pausing and shutting down service", "from", r.RemoteAddr)
 	a.Stop()
-	a.Pause()
 	os.Exit(0)
 }
 
 func (a *Adminz) abortHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("abortabortabort called! Shutting down service")
+	logger.Warn("abortabortabort called, shutting down service", "from", r.RemoteAddr)
 	os.Exit(0)
 }
-
-func (a *Adminz) gcHandler(w http.ResponseWriter, r *http.Request) {
-	var mem runtime.MemStats
-
-	mb := uint64(1024 * 1024)
-	runtime.ReadMemStats(&mem)
-	fmt.Fprintln(w, "Before")
-	fmt.Fprintln(w, "\tAlloc\t", mem.Alloc/mb)
-	fmt.Fprintln(w, "\tTotalAlloc:\t", mem.TotalAlloc/mb)
-	fmt.Fprintln(w, "\tHeapAlloc:\t", mem.HeapAlloc/mb)
-	fmt.Fprintln(w, "\tHeapSys:\t", mem.HeapSys/mb)
-	fmt.Fprintln(w, "\tSys:\t", mem.Sys/mb)
-
-	a.Lock()
-	was := a.doPause()
-	runtime.GC()
-	if was {
-		a.doResume()
-	}
-	a.Unlock()
-
-	runtime.ReadMemStats(&mem)
-	fmt.Fprintln(w, "After")
-	fmt.Fprintln(w, "\tAlloc\t", mem.Alloc/mb)
-	fmt.Fprintln(w, "\tTotalAlloc:\t", mem.TotalAlloc/mb)
-	fmt.Fprintln(w, "\tHeapAlloc:\t", mem.HeapAlloc/mb)
-	fmt.Fprintln(w, "\tHeapSys:\t", mem.HeapSys/mb)
-	fmt.Fprintln(w, "\tSys:\t", mem.Sys/mb)
-
-	w.Write([]byte("OK"))
-}
@@ -2,20 +2,30 @@ package adminz
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/foursquare/fsgo/adminz/expvarz"
+	"github.com/foursquare/fsgo/logging"
 	"github.com/stretchr/testify/assert"
 )
 
-func ExampleAdminz_Build() {
+func ExampleAdminz_Start() {
 	// To set up the adminz pages, first call New, then add whichever handlers
-	// you need, then call build.
+	// you need, then call Start.
 	a := New()
 	a.OnPause(func() { /* do a thing */ })
 	a.OnResume(func() { /* do a thing */ })
@@ -35,7 +45,7 @@ func TestKillfile(t *testing.T) {
 
 	checkInterval := 50 * time.Millisecond
 
-	pauseCounter := new(int)
+	pauseCounter := new(int32)
 
 	*pauseCounter = 1
 
@@ -47,62 +57,687 @@ func TestKillfile(t *testing.T) {
 	a.KillfilePaths([]string{killfile})
 	a.KillfileInterval(checkInterval)
 	a.OnPause(func() {
-		*pauseCounter += 1
+		atomic.AddInt32(pauseCounter, 1)
 	})
 	a.OnResume(func() {
-		*pauseCounter -= 1
+		atomic.AddInt32(pauseCounter, -1)
 	})
 	a.Start()
 	defer a.Stop()
 
-	assert.Equal(t, *pauseCounter, 0, "Pause shouldn't be called yet")
+	assert.Equal(t, int32(0), atomic.LoadInt32(pauseCounter), "Pause shouldn't be called yet")
 	assert.Equal(t, ok, string(readAllURL(t, url)), "Server should be healthy")
 
-	assert.True(t, a.running, "Killfile shouldn't exist")
+	assert.Equal(t, Serving, a.State(), "Killfile shouldn't exist")
 	k, err := os.Create(killfile)
 	assert.Nil(t, err, "Unable to create killfile")
 	defer k.Close()
 
 	// Sleep for 2 seconds to ensure the ticker has run
 	time.Sleep(checkInterval * 2)
-	assert.False(t, a.running, "Killfile missed")
-	assert.Equal(t, *pauseCounter, 1, "Didn't call pause")
+	assert.Equal(t, Draining, a.State(), "Killfile missed")
+	assert.Equal(t, int32(1), atomic.LoadInt32(pauseCounter), "Didn't call pause")
 	assert.Equal(t, notOK, string(readAllURL(t, url)), "Server should not be healthy")
 
 	time.Sleep(checkInterval * 2)
-	assert.Equal(t, *pauseCounter, 1, "Pause should only be called once")
+	assert.Equal(t, int32(1), atomic.LoadInt32(pauseCounter), "Pause should only be called once")
 
 	// Now remove and ensure we reset to running
 	os.Remove(killfile)
 
 	time.Sleep(checkInterval * 2)
-	assert.Equal(t, *pauseCounter, 0, "Resume should have been called")
-	assert.True(t, a.running, "Killfile shouldn't exist")
+	assert.Equal(t, int32(0), atomic.LoadInt32(pauseCounter), "Resume should have been called")
+	assert.Equal(t, Serving, a.State(), "Killfile shouldn't exist")
 	assert.Equal(t, ok, string(readAllURL(t, url)), "Server should be healthy")
 }
 
-func TestServicez(t *testing.T) {
-	servicez := []byte("{\"hello\":5}")
+func TestKillfileReasonAndExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminz")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	killfile := path.Join(dir, "kill")
+
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	url := ts.URL + "/healthz"
+
+	// changes should be noticed long before the next poll.
+	a.KillfilePaths([]string{killfile})
+	a.KillfileInterval(time.Hour)
+	a.Start()
+	defer a.Stop()
+	assert.Equal(t, "OK", string(readAllURL(t, url)))
+
+	ioutil.WriteFile(killfile, []byte("reason: deploying\nexpires: 200ms\n"), 0644)
+	time.Sleep(50 * time.Millisecond)
+	body := string(readAllURL(t, url))
+	assert.True(t, strings.HasPrefix(body, "Service Unavailable: deploying (until "), body)
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, "OK", string(readAllURL(t, url)), "killfile should have expired")
+}
+
+func TestParseKillfile(t *testing.T) {
+	since := time.Date(2016, 10, 20, 15, 0, 0, 0, time.UTC)
+
+	k := parseKillfile("/kill", since, "")
+	assert.Equal(t, "", k.Reason)
+	assert.True(t, k.Expires.IsZero())
+	assert.Equal(t, "/kill", k.String())
+
+	k = parseKillfile("/kill", since, "bad hardware\n")
+	assert.Equal(t, "bad hardware", k.Reason)
+
+	k = parseKillfile("/kill", since, "reason: maintenance\nexpires: 2016-10-20T16:00:00Z\n")
+	assert.Equal(t, "maintenance", k.Reason)
+	assert.Equal(t, since.Add(time.Hour), k.Expires.UTC())
+	assert.False(t, k.expired(since))
+	assert.True(t, k.expired(since.Add(time.Hour)))
+	assert.Equal(t, "/kill: maintenance (until 2016-10-20T16:00:00Z)", k.String())
+
+	k = parseKillfile("/kill", since, "expires: 30m")
+	assert.Equal(t, since.Add(30*time.Minute), k.Expires)
+
+	k = parseKillfile("/kill", since, "expires: whenever")
+	assert.True(t, k.Expires.IsZero(), "bad expiry should be ignored rather than end the pause")
+}
+
+func TestControls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminz")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	killfile := path.Join(dir, "kill")
+
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.KillfilePaths([]string{killfile})
+	a.KillfileInterval(10 * time.Millisecond)
+	a.Controls(TokenAuth(map[string]string{"s3cret": "ops"}))
+	a.Start()
+	defer a.Stop()
+
+	post := func(path string) int {
+		req, _ := http.NewRequest("POST", ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	health := func() string { return string(readAllURL(t, ts.URL+"/healthz")) }
+
+	res, err := http.Get(ts.URL + "/pausez")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	res, err = http.Post(ts.URL+"/pausez", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, "OK", health())
+
+	assert.Equal(t, 200, post("/pausez?reason=testing"))
+	assert.Equal(t, "Service Unavailable: paused by ops: testing", health())
+	assert.Equal(t, 200, post("/resumez"))
+	assert.Equal(t, "OK", health())
+
+	// killfiles always win over resuming.
+	ioutil.WriteFile(killfile, nil, 0644)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, http.StatusConflict, post("/resumez"))
+	assert.Equal(t, "Service Unavailable", health())
+
+	// and a pause outlasts a killfile.
+	assert.Equal(t, 200, post("/pausez"))
+	os.Remove(killfile)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "Service Unavailable: paused by ops", health())
+
+	assert.Equal(t, 400, post("/maintenancez?duration=forever"))
+	assert.Equal(t, 200, post("/maintenancez?duration=100ms&reason=upgrade"))
+	assert.True(t, strings.HasPrefix(health(), "Service Unavailable: paused by ops: upgrade (until "))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "OK", health(), "maintenance should have ended")
+
+	var audit []AuditEntry
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/auditz?format=json"), &audit))
+	var actions []string
+	for _, e := range audit {
+		actions = append(actions, e.Who+" "+e.Action)
+	}
+	assert.Equal(t, []string{
+		"ops maintenance ended", "ops maintenance", "killfile resume", "ops pause",
+		"ops resume", "killfile pause", "ops resume", "ops pause",
+	}, actions)
+}
+
+func TestMaintenanceTimer(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.KillfilePaths([]string{})
+	a.Controls(TokenAuth(map[string]string{"s3cret": "ops"}))
+	a.Start()
+
+	post := func(path string) {
+		req, _ := http.NewRequest("POST", ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+	held := func() *hold {
+		a.Lock()
+		defer a.Unlock()
+		return a.hold
+	}
+
+	post("/maintenancez?duration=1h")
+	first := held()
+	post("/maintenancez?duration=2h")
+	assert.False(t, first.timer.Stop(), "replaced window's timer should be stopped")
+	second := held()
+	post("/resumez")
+	assert.False(t, second.timer.Stop(), "resuming should stop the timer")
+
+	post("/maintenancez?duration=1h")
+	third := held()
+	a.Stop()
+	assert.False(t, third.timer.Stop(), "stopping should stop the timer")
+}
+
+func TestFlagz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminz")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	flagFile := path.Join(dir, "flags.json")
+
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Controls(TokenAuth(map[string]string{"s3cret": "ops"})).FlagFile(flagFile)
+	verbose := a.Flags().Bool("verbose", false, "log every request")
+	workers := a.Flags().Int("workers", 4, "worker pool size").Range(1, 64)
+	timeout := a.Flags().Duration("timeout", time.Second, "")
+	level := a.Flags().String("level", "info", "").OneOf("debug", "info", "warn")
+	var changes []string
+	workers.OnChange(func(old, new int) { changes = append(changes, fmt.Sprint(old, "->", new)) })
+	a.Start()
+	defer a.Stop()
+
+	set := func(name, value string) int {
+		form := url.Values{"name": {name}, "value": {value}}
+		req, _ := http.NewRequest("POST", ts.URL+"/flagz", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	res, err := http.PostForm(ts.URL+"/flagz", url.Values{"name": {"verbose"}, "value": {"true"}})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	assert.Equal(t, 200, set("verbose", "true"))
+	assert.Equal(t, 200, set("workers", "16"))
+	assert.Equal(t, 200, set("timeout", "250ms"))
+	assert.Equal(t, 400, set("workers", "100"), "out of range")
+	assert.Equal(t, 400, set("workers", "lots"))
+	assert.Equal(t, 400, set("level", "trace"))
+	assert.Equal(t, 404, set("colour", "blue"))
+
+	assert.True(t, verbose.Get())
+	assert.Equal(t, 16, workers.Get())
+	assert.Equal(t, 250*time.Millisecond, timeout.Get())
+	assert.Equal(t, "info", level.Get())
+	assert.Equal(t, []string{"4->16"}, changes)
+
+	var flags []FlagInfo
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/flagz?format=json"), &flags))
+	assert.Equal(t, FlagInfo{"workers", "int", "16", "4", "worker pool size"}, flags[3])
+
+	var audit []AuditEntry
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/auditz?format=json"), &audit))
+	assert.Equal(t, AuditEntry{audit[0].Time, "ops", "set flag", "timeout=250ms (was 1s)"}, audit[0])
+
+	// a restarted server restores changed values, unless they are no longer valid.
+	restarted := New().FlagFile(flagFile)
+	assert.True(t, restarted.Flags().Bool("verbose", false, "").Get())
+	assert.Equal(t, 4, restarted.Flags().Int("workers", 4, "").Range(1, 8).Get(), "16 is now out of range")
+	assert.Equal(t, 250*time.Millisecond, restarted.Flags().Duration("timeout", time.Second, "").Get())
+}
+
+func TestLogz(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Controls(TokenAuth(map[string]string{"s3cret": "ops"}))
+	a.Start()
+	defer a.Stop()
+
+	test := logging.New("adminz-test")
+	test.Debug("hidden")
+	test.Warn("something odd", "count", 3)
+
+	setLevel := func(name, level string) int {
+		form := url.Values{"name": {name}, "level": {level}}
+		req, _ := http.NewRequest("POST", ts.URL+"/logz", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, 400, setLevel("adminz-test", "loud"))
+	assert.Equal(t, 404, setLevel("no-such-logger", "debug"))
+	assert.Equal(t, 200, setLevel("adminz-test", "debug"))
+	assert.Equal(t, logging.Debug, test.Level())
+	test.Debug("shown")
+
+	var logz Logz
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/logz?format=json&n=5"), &logz))
+	assert.Contains(t, logz.Loggers, LoggerInfo{"adminz-test", logging.Debug})
+	assert.Contains(t, logz.Loggers, LoggerInfo{"adminz", logging.Info})
+	var messages []string
+	for _, e := range logz.Recent {
+		if e.Logger == "adminz-test" {
+			messages = append(messages, e.Message)
+		}
+	}
+	assert.Equal(t, []string{"shown", "something odd"}, messages)
+
+	html := string(readAllURL(t, ts.URL+"/logz?level=warn"))
+	assert.Contains(t, html, "WARN [adminz-test] something odd count=3")
+	assert.NotContains(t, html, "[adminz-test] shown")
+}
+
+func TestGC(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	pauses := 0
+	a.OnPause(func() { pauses++ })
+	a.Start()
+	defer a.Stop()
+
+	var report GCReport
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/gc?format=json"), &report))
+	assert.True(t, report.After.NumGC > report.Before.NumGC)
+	assert.False(t, report.Paused)
+	assert.Equal(t, 0, pauses, "pausing around GC is opt-in")
+	count := 0
+	for _, b := range report.Pauses {
+		count += b.Count
+	}
+	assert.True(t, count > 0)
+
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/gc?format=json&pause=true&free=true"), &report))
+	assert.True(t, report.Paused)
+	assert.True(t, report.Freed)
+	assert.Equal(t, 1, pauses)
+	assert.True(t, a.pauseState().Running, "resumed after collecting")
+
+	var raw struct {
+		Before map[string]json.Number `json:"before"`
+	}
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/gc?format=json"), &raw))
+	assert.Contains(t, raw.Before, "heap_inuse")
+	assert.Contains(t, raw.Before, "gc_cpu_fraction")
+
+	html := string(readAllURL(t, ts.URL+"/gc"))
+	assert.Contains(t, html, "<td>HeapObjects</td>")
+	assert.Contains(t, html, "iB</td>")
+
+	res, err := http.Get(ts.URL + "/gc?profile=heap")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, `attachment; filename="heap"`, res.Header.Get("Content-Disposition"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "3.0 GiB", formatBytes(3<<30))
+}
+
+func TestAccess(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Page("/secretz", "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	a.Protect("/secretz", Access{LoopbackOnly: true, Auth: TokenAuth(map[string]string{"s3cret": "ops"})})
+	a.DefaultAccess(Access{Methods: []string{"GET"}})
+	a.Start()
+	defer a.Stop()
+
+	do := func(method, path, token string) int {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
 
-	// create an unmarshalled version of above
-	servicezMap := make(map[string]int)
-	servicezMap["hello"] = 5
-	err := json.Unmarshal(servicez, &servicezMap)
+	// destructive pages must be POSTed, and confirmed.
+	assert.Equal(t, http.StatusMethodNotAllowed, do("GET", "/quitquitquit", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, do("GET", "/abortabortabort", ""))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/quitquitquit", ""))
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/secretz", ""))
+	assert.Equal(t, http.StatusOK, do("GET", "/secretz", "s3cret"))
+	assert.Equal(t, http.StatusOK, do("POST", "/secretz", "s3cret"), "own Access replaces the default")
+
+	assert.Equal(t, http.StatusOK, do("GET", "/healthz", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, do("POST", "/healthz", ""))
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.True(t, isLoopback("127.0.0.1:1234"))
+	assert.True(t, isLoopback("[::1]:1234"))
+	assert.False(t, isLoopback("10.0.0.1:1234"))
+	assert.False(t, isLoopback("not an address"))
+}
+
+func TestListen(t *testing.T) {
+	a := New().Listen("127.0.0.1:0")
+	assert.Equal(t, "", a.Addr())
+	a.Start()
+	addr := a.Addr()
+	assert.NotEqual(t, "", addr)
+	assert.Equal(t, "OK", string(readAllURL(t, "http://"+addr+"/healthz")))
+
+	a.Stop()
+	_, err := http.Get("http://" + addr + "/healthz")
+	assert.NotNil(t, err, "stopped serving")
+}
+
+func TestListenFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	assert.Nil(t, err)
+	defer l.Close()
+
+	a := New().Listen(l.Addr().String())
+	assert.NotNil(t, a.StartErr(), "address in use")
+	assert.Equal(t, Starting, a.State())
+	assert.Panics(t, func() { a.Start() })
+}
 
+func TestLifecycle(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+
+	warm := make(chan bool)
+	var transitions []string
+	for _, state := range []State{Warming, Serving, Draining, Stopped} {
+		to := state
+		a.OnState(to, func(from State) { transitions = append(transitions, from.String()+"->"+to.String()) })
+	}
+	a.Warmup(func() error { <-warm; return nil })
+	assert.Equal(t, Starting, a.State())
+	a.Start()
+
+	health := func() (int, string) {
+		res, err := http.Get(ts.URL + "/healthz")
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, a.State().String(), res.Header.Get("X-Server-State"))
+		return res.StatusCode, string(body)
+	}
+
+	assert.Equal(t, Warming, a.State())
+	code, body := health()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "Service Unavailable: warming", body)
+	a.Resume()
+	assert.Equal(t, Warming, a.State(), "can't resume before warming up")
+
+	warm <- true
+	for i := 0; i < 100 && a.State() == Warming; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	code, _ = health()
+	assert.Equal(t, 200, code)
+
+	a.Pause()
+	a.Resume()
+	a.Stop()
+	assert.Equal(t, Stopped, a.State())
+	a.Resume()
+	assert.Equal(t, Stopped, a.State(), "can't resume once stopped")
+	assert.Equal(t, []string{
+		"starting->warming", "warming->serving", "serving->draining", "draining->serving",
+		"serving->draining", "draining->stopped",
+	}, transitions)
+}
+
+func TestFailedWarmup(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Warmup(func() error { return errors.New("no cache") })
+	a.Start()
+	defer a.Stop()
+
+	var body string
+	for i := 0; i < 100 && !strings.Contains(body, "failed"); i++ {
+		time.Sleep(time.Millisecond)
+		body = string(readAllURL(t, ts.URL+"/healthz"))
+	}
+	assert.Equal(t, "Service Unavailable: warm-up failed: no cache", body)
+	assert.Equal(t, Warming, a.State())
+}
+
+func blockedWorker(c chan bool) {
+	<-c
+}
+
+func TestDebug(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Debug(DebugOptions{Profiling: true, Goroutines: true, Expvar: expvarz.Vars, Auth: TokenAuth(map[string]string{"t": "dev"})})
+	a.Start()
+	defer a.Stop()
+
+	get := func(path string) (int, []byte) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer t")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, body
+	}
+
+	res, err := http.Get(ts.URL + "/debug/pprof/heap")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	code, body := get("/debug/pprof/")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, string(body), "goroutine")
+	code, body = get("/debug/pprof/heap?debug=1")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, string(body), "heap profile")
+	code, _ = get("/debug/pprof/nope")
+	assert.Equal(t, 404, code)
+	code, body = get("/debug/pprof/profile?seconds=0.1")
+	assert.Equal(t, 200, code)
+	assert.NotEmpty(t, body)
+
+	c := make(chan bool)
+	defer close(c)
+	for i := 0; i < 5; i++ {
+		go blockedWorker(c)
+	}
+	time.Sleep(10 * time.Millisecond)
+	code, body = get("/goroutinez?format=json")
+	assert.Equal(t, 200, code)
+	var groups []GoroutineGroup
+	assert.Nil(t, json.Unmarshal(body, &groups))
+	found := false
+	for _, g := range groups {
+		if strings.HasPrefix(g.Stack[0], "github.com/foursquare/fsgo/adminz.blockedWorker") {
+			found = true
+			assert.Equal(t, 5, g.Count, "identical stacks should be grouped: %v", g.Stack)
+			assert.Equal(t, 5, g.States["chan receive"])
+		}
+	}
+	assert.True(t, found)
+
+	code, body = get("/debug/vars")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, string(body), `"adminz": {"state":"serving","running":true`)
+	assert.True(t, json.Valid(body))
+}
+
+func TestServicez(t *testing.T) {
 	// fake out an http server, add trivial servicez
 	ts, a := newTestAdminz()
 	defer ts.Close()
 	a.Servicez(func() interface{} {
-		return servicezMap
+		return map[string]int{"hello": 5}
 	})
 	a.Start()
 	defer a.Stop()
 
-	assert.Equal(t, string(servicez), string(readAllURL(t, ts.URL+"/servicez")))
+	var info struct {
+		ServiceInfo
+		Service map[string]int `json:"service"`
+	}
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/servicez"), &info))
+	assert.Equal(t, map[string]int{"hello": 5}, info.Service)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, os.Getpid(), info.Pid)
+	assert.Equal(t, os.Args, info.Cmdline)
+	assert.Equal(t, runtime.GOMAXPROCS(0), info.GOMAXPROCS)
+	assert.False(t, info.StartTime.IsZero())
+	assert.NotEmpty(t, info.Uptime)
+	assert.True(t, info.State.Running)
+	assert.Equal(t, Serving, info.State.State)
+
+	a.Pause()
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/servicez"), &info))
+	assert.False(t, info.State.Running)
+	assert.Equal(t, Draining, info.State.State)
+}
+
+func TestServicezWithoutPayload(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Start()
+	defer a.Stop()
+
+	var info map[string]json.RawMessage
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/servicez"), &info))
+	assert.Equal(t, "{}", string(info["service"]))
+	assert.Contains(t, info, "build")
+	assert.Contains(t, info, "go_version")
+	assert.Contains(t, info, "start_time")
+}
+
+type describedPage struct{}
+
+func (describedPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Render(w, r, "described", map[string]int{"answer": 42}, nil)
+}
+
+func (describedPage) AdminDescription() string { return "A described page" }
+
+func TestIndex(t *testing.T) {
+	ts, a := newTestAdminz()
+	defer ts.Close()
+	a.Handle("/describedz", describedPage{})
+	a.Page("/otherz", "Another page", http.NotFoundHandler())
+	a.Start()
+	defer a.Stop()
+
+	html := string(readAllURL(t, ts.URL+"/adminz"))
+	assert.Contains(t, html, `<a href="/healthz">/healthz</a>`)
+	assert.Contains(t, html, "A described page")
+	assert.Contains(t, html, "Another page")
+	assert.NotContains(t, html, "/pausez", "control pages are only listed when enabled")
+
+	var pages []PageInfo
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/adminz?format=json"), &pages))
+	descriptions := make(map[string]string)
+	for _, p := range pages {
+		descriptions[p.Path] = p.Description
+	}
+	assert.Equal(t, "A described page", descriptions["/describedz"])
+	assert.Equal(t, "Another page", descriptions["/otherz"])
+	assert.Contains(t, descriptions, "/gc")
+
+	// pages rendered with Render serve HTML by default and JSON on request.
+	assert.Contains(t, string(readAllURL(t, ts.URL+"/describedz")), "<title>described</title>")
+	req, _ := http.NewRequest("GET", ts.URL+"/describedz", nil)
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"answer":42}`, string(body))
+}
+
+func TestMultipleInstances(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	first := New().ServeMux(mux).Healthy(func() bool { return false }).Start()
+	second := New().ServeMux(mux).Start()
+	assert.Equal(t, "OK", string(readAllURL(t, ts.URL+"/healthz")), "the second replaces the first")
+
+	second.Stop()
+	assert.Equal(t, 404, status(t, ts.URL+"/healthz"), "stopping unmounts")
+	first.Stop()
+	mountsLock.Lock()
+	_, remembered := mounts[mux]
+	mountsLock.Unlock()
+	assert.False(t, remembered, "the mux is forgotten once nothing is mounted on it")
+
+	third := New().ServeMux(mux).Start()
+	defer third.Stop()
+	assert.Equal(t, "OK", string(readAllURL(t, ts.URL+"/healthz")))
+}
+
+func TestPrefix(t *testing.T) {
+	a := New().Prefix("/admin").Page("/extraz", "Extra", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Render(w, r, "extra", nil, func(w io.Writer) { io.WriteString(w, r.URL.Path) })
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", a.Handler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	a.Start()
+	defer a.Stop()
+
+	assert.Equal(t, "OK", string(readAllURL(t, ts.URL+"/admin/healthz")))
+	assert.Equal(t, 404, status(t, ts.URL+"/healthz"))
+
+	page := string(readAllURL(t, ts.URL+"/admin/extraz"))
+	assert.Contains(t, page, `<a href="/admin/adminz">`)
+	assert.Contains(t, page, "/extraz", "pages see their path without the prefix")
+
+	var pages []PageInfo
+	assert.Nil(t, json.Unmarshal(readAllURL(t, ts.URL+"/admin/adminz?format=json"), &pages))
+	assert.Contains(t, pages, PageInfo{"/admin/extraz", "Extra"})
+}
+
+func TestDefaultServeMux(t *testing.T) {
+	ts := httptest.NewServer(http.DefaultServeMux)
+	defer ts.Close()
+
+	// starting twice on the default mux would panic if pages were registered directly.
+	for i := 0; i < 2; i++ {
+		a := New().Debug(DebugOptions{Expvar: expvarz.Vars}).Start()
+		assert.Equal(t, "OK", string(readAllURL(t, ts.URL+"/healthz")))
+		assert.Equal(t, 200, status(t, ts.URL+"/debug/vars"))
+		a.Stop()
+	}
 }
 
-// This test must be last as it uses the DefaultServeMux
 func TestStartNoInputs(t *testing.T) {
 	mux := http.NewServeMux()
 	ts := httptest.NewServer(mux)
@@ -114,6 +749,13 @@ func TestStartNoInputs(t *testing.T) {
 	defer a.Stop()
 }
 
+func status(t *testing.T, url string) int {
+	res, err := http.Get(url)
+	assert.Nil(t, err)
+	res.Body.Close()
+	return res.StatusCode
+}
+
 func readAllURL(t *testing.T, url string) []byte {
 	res, err := http.Get(url)
 	assert.Nil(t, err)
@@ -0,0 +1,222 @@
+package adminz
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many state changes the audit log keeps.
+const auditLogSize = 100
+
+// Identifies who is making an admin request, or returns an error if they may not.
+type ControlAuth func(r *http.Request) (who string, err error)
+
+var errBadToken = errors.New("missing or invalid admin token")
+
+// TokenAuth accepts requests with an `Authorization: Bearer <token>` header for one of tokens,
+// which map each token to who it identifies.
+func TokenAuth(tokens map[string]string) ControlAuth {
+	return func(r *http.Request) (string, error) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", errBadToken
+		}
+		given := []byte(strings.TrimPrefix(auth, "Bearer "))
+		// compare against every token, in constant time, so timing reveals nothing about them.
+		who := ""
+		for token, w := range tokens {
+			if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+				who = w
+			}
+		}
+		if who == "" {
+			return "", errBadToken
+		}
+		return who, nil
+	}
+}
+
+// Identifies who is making a change, such as setting a flag, with the Controls auth, or writes an
+// error response and returns false if they may not.
+func (a *Adminz) authorizeChange(w http.ResponseWriter, r *http.Request, what string) (string, bool) {
+	if a.controlAuth == nil {
+		http.Error(w, what+" needs Controls to be enabled", http.StatusForbidden)
+		return "", false
+	}
+	who, err := a.controlAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return who, true
+}
+
+// A pause requested over HTTP, rather than by a killfile. Until is zero for an indefinite pause.
+type hold struct {
+	who    string
+	reason string
+	since  time.Time
+	until  time.Time
+	// ends a maintenance window at until.
+	timer *time.Timer
+}
+
+func (h *hold) String() string {
+	return "paused by " + h.describe()
+}
+
+func (h *hold) describe() string {
+	s := h.who
+	if h.reason != "" {
+		s += ": " + h.reason
+	}
+	if !h.until.IsZero() {
+		s += " (until " + h.until.Format(time.RFC3339) + ")"
+	}
+	return s
+}
+
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Who    string    `json:"who"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// A bounded log of who paused and resumed the server.
+type auditLog struct {
+	sync.Mutex
+	entries []AuditEntry
+}
+
+func (l *auditLog) add(who, action, detail string) {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.entries) == auditLogSize {
+		l.entries = l.entries[1:]
+	}
+	l.entries = append(l.entries, AuditEntry{time.Now(), who, action, detail})
+}
+
+// Most recent first.
+func (l *auditLog) recent() []AuditEntry {
+	l.Lock()
+	defer l.Unlock()
+	res := make([]AuditEntry, len(l.entries))
+	for i, e := range l.entries {
+		res[len(res)-1-i] = e
+	}
+	return res
+}
+
+// Controls enables POST endpoints to pause (/pausez), resume (/resumez) and pause for a
+// maintenance window (/maintenancez?duration=30m), each with an optional reason parameter, for
+// requests that auth accepts. A present killfile always wins: resuming only lifts pauses made
+// over HTTP. Changes are recorded, with who made them, on /auditz.
+func (a *Adminz) Controls(auth ControlAuth) *Adminz {
+	a.controlAuth = auth
+	return a
+}
+
+// Pauses until resumed, or until `until` if it is not zero. Must be called while holding a.Lock.
+func (a *Adminz) doHold(h *hold) {
+	a.clearHold()
+	a.hold = h
+	a.doPause()
+	if !h.until.IsZero() {
+		h.timer = time.AfterFunc(h.until.Sub(h.since), func() { a.endHold(h) })
+	}
+}
+
+// Lifts any pause made over HTTP, stopping its maintenance window's timer. Must be called while
+// holding a.Lock.
+func (a *Adminz) clearHold() {
+	if a.hold != nil && a.hold.timer != nil {
+		a.hold.timer.Stop()
+	}
+	a.hold = nil
+}
+
+// Ends a maintenance window, if it is still in effect.
+func (a *Adminz) endHold(h *hold) {
+	a.Lock()
+	defer a.Unlock()
+	if a.hold != h {
+		return
+	}
+	a.hold = nil
+	a.audit.add(h.who, "maintenance ended", "")
+	logger.Info("maintenance window ended", "hold", h)
+	if a.killfile == nil {
+		a.doResume()
+	}
+}
+
+func (a *Adminz) controlHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Must POST", http.StatusMethodNotAllowed)
+			return
+		}
+		who, err := a.controlAuth(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		reason := r.FormValue("reason")
+
+		a.Lock()
+		defer a.Unlock()
+
+		switch action {
+		case "pause":
+			a.doHold(&hold{who: who, reason: reason, since: time.Now()})
+		case "maintenance":
+			d, err := time.ParseDuration(r.FormValue("duration"))
+			if err != nil || d <= 0 {
+				http.Error(w, "duration must be a positive duration, eg 30m", http.StatusBadRequest)
+				return
+			}
+			now := time.Now()
+			a.doHold(&hold{who: who, reason: reason, since: now, until: now.Add(d)})
+			reason = strings.TrimSpace(fmt.Sprintf("%s for %s", reason, d))
+		case "resume":
+			a.clearHold()
+			if a.killfile == nil {
+				a.doResume()
+			}
+		}
+		a.audit.add(who, action, reason)
+		logger.Warn(action, "by", who, "from", r.RemoteAddr, "reason", reason)
+
+		if action == "resume" && a.killfile != nil {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintln(w, "Still paused by killfile", a.killfile)
+			return
+		}
+		if a.hold != nil {
+			fmt.Fprintln(w, "Paused:", a.hold.describe())
+			return
+		}
+		fmt.Fprintln(w, "Resumed")
+	}
+}
+
+var auditTemplate = template.Must(template.New("auditz").Parse(`<table>
+<tr><th>time</th><th>who</th><th>action</th><th>detail</th></tr>
+{{range .}}<tr><td>{{.Time.Format "2006-01-02T15:04:05Z07:00"}}</td><td>{{.Who}}</td><td>{{.Action}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>`))
+
+func (a *Adminz) auditHandler(w http.ResponseWriter, r *http.Request) {
+	entries := a.audit.recent()
+	Render(w, r, "auditz", entries, func(w io.Writer) {
+		auditTemplate.Execute(w, entries)
+	})
+}
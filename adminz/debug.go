@@ -0,0 +1,272 @@
+package adminz
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DebugOptions struct {
+	// Serves CPU, heap, block, mutex, goroutine and other profiles, and execution traces, under
+	// /debug/pprof/, in the format `go tool pprof` and `go tool trace` expect.
+	Profiling bool
+	// Passed to runtime.SetBlockProfileRate and runtime.SetMutexProfileFraction if non-zero, as
+	// block and mutex profiles are empty unless enabled.
+	BlockProfileRate     int
+	MutexProfileFraction int
+	// Serves a readable dump of all goroutines, grouped by stack, on /goroutinez.
+	Goroutines bool
+	// If set, eg to expvarz.Vars, serves published expvars, including adminz's own state, on
+	// /debug/vars.
+	Expvar Expvars
+	// If set, debug pages are only served to requests it accepts.
+	Auth ControlAuth
+}
+
+// Publishes and serves expvars. adminz doesn't import expvar itself, as importing it registers an
+// unauthenticated /debug/vars on http.DefaultServeMux; the expvarz package implements this.
+type Expvars interface {
+	// Publishes f's result as the var named name. Called at most once per name.
+	Publish(name string, f func() interface{})
+	// Serves every published var as a JSON object.
+	http.Handler
+}
+
+// Debug enables profiling and debugging pages on the adminz mux, as chosen by opts.
+func (a *Adminz) Debug(opts DebugOptions) *Adminz {
+	a.debug = &opts
+	return a
+}
+
+// Longest CPU profile or trace a request may ask for.
+const maxProfileDuration = 5 * time.Minute
+
+func (a *Adminz) debugPages() []page {
+	opts := a.debug
+	var pages []page
+	add := func(path, description string, h http.HandlerFunc) {
+		pages = append(pages, page{path, description, a.debugAuth(h)})
+	}
+
+	if opts.Profiling {
+		if opts.BlockProfileRate != 0 {
+			runtime.SetBlockProfileRate(opts.BlockProfileRate)
+		}
+		if opts.MutexProfileFraction != 0 {
+			runtime.SetMutexProfileFraction(opts.MutexProfileFraction)
+		}
+		add("/debug/pprof/", "Heap, goroutine, block, mutex and other profiles, for go tool pprof", profileHandler)
+		add("/debug/pprof/profile", "CPU profile (?seconds=30), for go tool pprof", cpuProfileHandler)
+		add("/debug/pprof/trace", "Execution trace (?seconds=1), for go tool trace", traceHandler)
+	}
+	if opts.Goroutines {
+		add("/goroutinez", "All goroutines, grouped by stack", goroutinesHandler)
+	}
+	if opts.Expvar != nil {
+		publishOnce.Do(func() {
+			opts.Expvar.Publish("adminz", lastStarted.state)
+		})
+		lastStarted.set(a)
+		add("/debug/vars", "Published expvars", opts.Expvar.ServeHTTP)
+	}
+	return pages
+}
+
+func (a *Adminz) debugAuth(h http.HandlerFunc) http.Handler {
+	if a.debug.Auth == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := a.debug.Auth(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// expvar names are global, so only one adminz's state can be published.
+var (
+	publishOnce sync.Once
+	lastStarted startedAdminz
+)
+
+type startedAdminz struct {
+	sync.Mutex
+	a *Adminz
+}
+
+func (s *startedAdminz) set(a *Adminz) {
+	s.Lock()
+	defer s.Unlock()
+	s.a = a
+}
+
+func (s *startedAdminz) state() interface{} {
+	s.Lock()
+	a := s.a
+	s.Unlock()
+	return a.pauseState()
+}
+
+// Serves the named profile, eg /debug/pprof/heap, or an index of them.
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, "profile?seconds=30\ntrace?seconds=5")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%s (%d)\n", p.Name(), p.Count())
+		}
+		return
+	}
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "Unknown profile "+name, http.StatusNotFound)
+		return
+	}
+	// debug=1 or 2 produces text rather than the binary format.
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	p.WriteTo(w, debug)
+}
+
+func profileDuration(r *http.Request, def time.Duration) time.Duration {
+	if secs, err := strconv.ParseFloat(r.FormValue("seconds"), 64); err == nil && secs > 0 {
+		if d := time.Duration(secs * float64(time.Second)); d < maxProfileDuration {
+			return d
+		}
+		return maxProfileDuration
+	}
+	return def
+}
+
+func cpuProfileHandler(w http.ResponseWriter, r *http.Request) {
+	d := profileDuration(r, 30*time.Second)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		http.Error(w, "Could not start CPU profile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sleep(r, d)
+	pprof.StopCPUProfile()
+}
+
+func traceHandler(w http.ResponseWriter, r *http.Request) {
+	d := profileDuration(r, 1*time.Second)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		http.Error(w, "Could not start trace: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sleep(r, d)
+	trace.Stop()
+}
+
+// Sleeps for d, or until the request is cancelled.
+func sleep(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
+
+// Goroutines with the same stack, such as a pool of workers all waiting on the same channel.
+type GoroutineGroup struct {
+	Count int `json:"count"`
+	// how many goroutines are in each state, eg "chan receive".
+	States map[string]int `json:"states"`
+	Stack  []string       `json:"stack"`
+}
+
+var (
+	goroutineHeader = regexp.MustCompile(`^goroutine \d+ \[([^,\]]+)`)
+	// call arguments, pc offsets and parent ids differ between goroutines with otherwise
+	// identical stacks.
+	stackNoise = regexp.MustCompile(`\(0x[0-9a-f, .{}]*\)|\(\.\.\.\)| \+0x[0-9a-f]+$| in goroutine \d+$`)
+)
+
+func goroutineGroups() []*GoroutineGroup {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	groups := make(map[string]*GoroutineGroup)
+	for _, dump := range bytes.Split(buf, []byte("\n\n")) {
+		lines := strings.Split(strings.TrimSpace(string(dump)), "\n")
+		m := goroutineHeader.FindStringSubmatch(lines[0])
+		if m == nil {
+			continue
+		}
+		stack := make([]string, len(lines)-1)
+		for i, line := range lines[1:] {
+			stack[i] = stackNoise.ReplaceAllString(strings.TrimSpace(line), "")
+		}
+		key := strings.Join(stack, "\n")
+		g, ok := groups[key]
+		if !ok {
+			g = &GoroutineGroup{States: make(map[string]int), Stack: stack}
+			groups[key] = g
+		}
+		g.Count++
+		g.States[m[1]]++
+	}
+
+	res := make([]*GoroutineGroup, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Count > res[j].Count })
+	return res
+}
+
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	groups := goroutineGroups()
+	Render(w, r, "goroutinez", groups, func(w io.Writer) {
+		fmt.Fprintf(w, "<p>%d goroutines</p>\n<pre>", runtime.NumGoroutine())
+		for _, g := range groups {
+			var states []string
+			for state, n := range g.States {
+				states = append(states, fmt.Sprintf("%d %s", n, state))
+			}
+			sort.Strings(states)
+			fmt.Fprintf(w, "\n<b>%d: %s</b>\n", g.Count, template.HTMLEscapeString(strings.Join(states, ", ")))
+			for i, line := range g.Stack {
+				// stacks alternate between functions and their locations.
+				indent := "\t"
+				if i%2 == 1 {
+					indent = "\t\t"
+				}
+				fmt.Fprintln(w, indent+template.HTMLEscapeString(line))
+			}
+		}
+		fmt.Fprintln(w, "</pre>")
+	})
+}
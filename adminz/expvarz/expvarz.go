@@ -0,0 +1,38 @@
+// Package expvarz serves expvars on adminz's /debug/vars page, behind its debug Auth:
+//
+//	adminz.New().Debug(adminz.DebugOptions{Expvar: expvarz.Vars, Auth: auth})
+//
+// It is kept out of adminz because importing expvar, as this package does, registers expvar's own
+// unauthenticated handler for /debug/vars on http.DefaultServeMux. Serve adminz on its own mux to
+// keep the page behind Auth.
+package expvarz
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// Vars publishes and serves vars with the expvar package.
+var Vars vars
+
+type vars struct{}
+
+func (vars) Publish(name string, f func() interface{}) {
+	expvar.Publish(name, expvar.Func(f))
+}
+
+// Writes published expvars as a JSON object, as expvar's own handler does.
+func (vars) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
@@ -0,0 +1,375 @@
+package adminz
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Flags holds tunables that can be viewed on /flagz and changed, while the server runs, by POSTing
+// name and value to it. Changes need Controls to be enabled, are recorded on /auditz and, with
+// FlagFile, are saved so that they survive restarts.
+type Flags struct {
+	sync.Mutex
+	flags map[string]*flagValue
+
+	// where changed values are saved, if anywhere
+	path string
+	// values read from path, applied to flags as they are registered
+	saved map[string]string
+}
+
+func newFlags() *Flags {
+	return &Flags{flags: make(map[string]*flagValue), saved: make(map[string]string)}
+}
+
+// The state every kind of flag shares. Values are held as interface{} and converted by the
+// typed wrappers.
+type flagValue struct {
+	name        string
+	kind        string
+	description string
+	def         interface{}
+	parse       func(string) (interface{}, error)
+
+	sync.Mutex
+	value    interface{}
+	validate func(interface{}) error
+	onChange []func(old, new interface{})
+}
+
+func (f *flagValue) get() interface{} {
+	f.Lock()
+	defer f.Unlock()
+	return f.value
+}
+
+// Parses, validates and stores s, then calls any OnChange callbacks.
+func (f *flagValue) set(s string) (old interface{}, err error) {
+	v, err := f.parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s for %s: %v", f.kind, f.name, err)
+	}
+	f.Lock()
+	if f.validate != nil {
+		if err := f.validate(v); err != nil {
+			f.Unlock()
+			return nil, fmt.Errorf("invalid value for %s: %v", f.name, err)
+		}
+	}
+	old = f.value
+	f.value = v
+	callbacks := f.onChange
+	f.Unlock()
+
+	if old != v {
+		for _, c := range callbacks {
+			c(old, v)
+		}
+	}
+	return old, nil
+}
+
+// Sets the validator, resetting the flag to its default if its current value, eg one restored
+// from the flag file, doesn't pass.
+func (f *flagValue) setValidate(validate func(interface{}) error) {
+	f.Lock()
+	defer f.Unlock()
+	f.validate = validate
+	if err := validate(f.value); err != nil {
+		logger.Warn("resetting invalid flag to its default", "flag", f.name, "value", f.value, "default", f.def, "err", err)
+		f.value = f.def
+	}
+}
+
+func (f *flagValue) addOnChange(c func(old, new interface{})) {
+	f.Lock()
+	defer f.Unlock()
+	f.onChange = append(f.onChange, c)
+}
+
+func (fs *Flags) add(name, kind, description string, def interface{}, parse func(string) (interface{}, error)) *flagValue {
+	fs.Lock()
+	defer fs.Unlock()
+	if _, ok := fs.flags[name]; ok {
+		panic("adminz: flag " + name + " registered twice")
+	}
+	f := &flagValue{name: name, kind: kind, description: description, def: def, parse: parse, value: def}
+	fs.flags[name] = f
+	// a new flag has no callbacks yet, so it can be restored under the lock.
+	if s, ok := fs.saved[name]; ok {
+		fs.restore(f, s)
+	}
+	return f
+}
+
+func (fs *Flags) restore(f *flagValue, s string) {
+	if _, err := f.set(s); err != nil {
+		logger.Warn("ignoring saved flag", "err", err)
+	}
+}
+
+func (fs *Flags) lookup(name string) *flagValue {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.flags[name]
+}
+
+// Reads saved values from path, applying them to flags registered so far, and to those registered
+// later as they are. A missing file is not an error: it is created on the first change.
+func (fs *Flags) load(path string) error {
+	fs.Lock()
+	fs.path = path
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		fs.Unlock()
+		return nil
+	} else if err != nil {
+		fs.Unlock()
+		return err
+	}
+	if err := json.Unmarshal(contents, &fs.saved); err != nil {
+		fs.Unlock()
+		return fmt.Errorf("unable to parse flag file %s: %v", path, err)
+	}
+	restore := make(map[*flagValue]string)
+	for name, s := range fs.saved {
+		if f, ok := fs.flags[name]; ok {
+			restore[f] = s
+		}
+	}
+	fs.Unlock()
+
+	// restored outside the lock, as OnChange callbacks may use the flags.
+	for f, s := range restore {
+		fs.restore(f, s)
+	}
+	return nil
+}
+
+// Records a changed value and rewrites the flag file, if there is one.
+func (fs *Flags) save(name, value string) error {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.saved[name] = value
+	if fs.path == "" {
+		return nil
+	}
+	contents, err := json.MarshalIndent(fs.saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written to a temporary file then renamed, so a crash can't leave a partial file.
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
+
+// Bool registers a boolean flag.
+func (fs *Flags) Bool(name string, def bool, description string) *BoolFlag {
+	return &BoolFlag{fs.add(name, "bool", description, def, func(s string) (interface{}, error) {
+		return strconv.ParseBool(s)
+	})}
+}
+
+// Int registers an integer flag.
+func (fs *Flags) Int(name string, def int, description string) *IntFlag {
+	return &IntFlag{fs.add(name, "int", description, def, func(s string) (interface{}, error) {
+		return strconv.Atoi(s)
+	})}
+}
+
+// Duration registers a flag holding a duration, eg "30s".
+func (fs *Flags) Duration(name string, def time.Duration, description string) *DurationFlag {
+	return &DurationFlag{fs.add(name, "duration", description, def, func(s string) (interface{}, error) {
+		return time.ParseDuration(s)
+	})}
+}
+
+// String registers a string flag.
+func (fs *Flags) String(name string, def string, description string) *StringFlag {
+	return &StringFlag{fs.add(name, "string", description, def, func(s string) (interface{}, error) {
+		return s, nil
+	})}
+}
+
+type BoolFlag struct{ f *flagValue }
+
+func (b *BoolFlag) Get() bool { return b.f.get().(bool) }
+
+// Validate rejects changes for which validate returns an error, replacing any previous validator.
+func (b *BoolFlag) Validate(validate func(bool) error) *BoolFlag {
+	b.f.setValidate(func(v interface{}) error { return validate(v.(bool)) })
+	return b
+}
+
+// OnChange calls onChange after each change of value.
+func (b *BoolFlag) OnChange(onChange func(old, new bool)) *BoolFlag {
+	b.f.addOnChange(func(old, new interface{}) { onChange(old.(bool), new.(bool)) })
+	return b
+}
+
+type IntFlag struct{ f *flagValue }
+
+func (i *IntFlag) Get() int { return i.f.get().(int) }
+
+// Validate rejects changes for which validate returns an error, replacing any previous validator.
+func (i *IntFlag) Validate(validate func(int) error) *IntFlag {
+	i.f.setValidate(func(v interface{}) error { return validate(v.(int)) })
+	return i
+}
+
+// Range rejects changes outside [min, max].
+func (i *IntFlag) Range(min, max int) *IntFlag {
+	return i.Validate(func(v int) error {
+		if v < min || v > max {
+			return fmt.Errorf("%d is not between %d and %d", v, min, max)
+		}
+		return nil
+	})
+}
+
+// OnChange calls onChange after each change of value.
+func (i *IntFlag) OnChange(onChange func(old, new int)) *IntFlag {
+	i.f.addOnChange(func(old, new interface{}) { onChange(old.(int), new.(int)) })
+	return i
+}
+
+type DurationFlag struct{ f *flagValue }
+
+func (d *DurationFlag) Get() time.Duration { return d.f.get().(time.Duration) }
+
+// Validate rejects changes for which validate returns an error, replacing any previous validator.
+func (d *DurationFlag) Validate(validate func(time.Duration) error) *DurationFlag {
+	d.f.setValidate(func(v interface{}) error { return validate(v.(time.Duration)) })
+	return d
+}
+
+// OnChange calls onChange after each change of value.
+func (d *DurationFlag) OnChange(onChange func(old, new time.Duration)) *DurationFlag {
+	d.f.addOnChange(func(old, new interface{}) { onChange(old.(time.Duration), new.(time.Duration)) })
+	return d
+}
+
+type StringFlag struct{ f *flagValue }
+
+func (s *StringFlag) Get() string { return s.f.get().(string) }
+
+// Validate rejects changes for which validate returns an error, replacing any previous validator.
+func (s *StringFlag) Validate(validate func(string) error) *StringFlag {
+	s.f.setValidate(func(v interface{}) error { return validate(v.(string)) })
+	return s
+}
+
+// OneOf rejects changes to anything but one of values.
+func (s *StringFlag) OneOf(values ...string) *StringFlag {
+	return s.Validate(func(v string) error {
+		for _, allowed := range values {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %q", v, values)
+	})
+}
+
+// OnChange calls onChange after each change of value.
+func (s *StringFlag) OnChange(onChange func(old, new string)) *StringFlag {
+	s.f.addOnChange(func(old, new interface{}) { onChange(old.(string), new.(string)) })
+	return s
+}
+
+// Flags returns the server's tunables, for registering them.
+func (a *Adminz) Flags() *Flags {
+	return a.flags
+}
+
+// FlagFile saves changed flag values to path, and restores them from it, so that they survive
+// restarts.
+func (a *Adminz) FlagFile(path string) *Adminz {
+	if err := a.flags.load(path); err != nil {
+		logger.Error("unable to restore flags", "err", err)
+	}
+	return a
+}
+
+type FlagInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`
+	Default     string `json:"default"`
+	Description string `json:"description,omitempty"`
+}
+
+func (fs *Flags) list() []FlagInfo {
+	fs.Lock()
+	defer fs.Unlock()
+	res := make([]FlagInfo, 0, len(fs.flags))
+	for _, f := range fs.flags {
+		res = append(res, FlagInfo{f.name, f.kind, fmt.Sprint(f.get()), fmt.Sprint(f.def), f.description})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+var flagzTemplate = template.Must(template.New("flagz").Parse(`<table>
+<tr><th>name</th><th>type</th><th>value</th><th>default</th><th>description</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Value}}</td><td>{{.Default}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+<p>POST name and value to change a flag.</p>`))
+
+func (a *Adminz) flagzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		flags := a.flags.list()
+		Render(w, r, "flagz", flags, func(w io.Writer) {
+			flagzTemplate.Execute(w, flags)
+		})
+		return
+	}
+
+	who, ok := a.authorizeChange(w, r, "Changing flags")
+	if !ok {
+		return
+	}
+	name, value := r.FormValue("name"), r.FormValue("value")
+	f := a.flags.lookup(name)
+	if f == nil {
+		http.Error(w, "Unknown flag "+name, http.StatusNotFound)
+		return
+	}
+	old, err := f.set(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	current := fmt.Sprint(f.get())
+	detail := fmt.Sprintf("%s=%s (was %v)", name, current, old)
+	a.audit.add(who, "set flag", detail)
+	logger.Warn("flag set", "by", who, "from", r.RemoteAddr, "flag", name, "value", current, "was", old)
+	if err := a.flags.save(name, current); err != nil {
+		logger.Error("unable to save flags", "err", err)
+		http.Error(w, "Set "+name+", but unable to save it: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "%s=%s\n", name, current)
+}
@@ -0,0 +1,221 @@
+package adminz
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// Memory use at a point in time. Sizes are in bytes.
+type MemSnapshot struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Sys          uint64 `json:"sys"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapSys      uint64 `json:"heap_sys"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+	StackInuse   uint64 `json:"stack_inuse"`
+	NextGC       uint64 `json:"next_gc"`
+	NumGC        uint32 `json:"num_gc"`
+	// total time the world has been stopped for GC since the process started.
+	PauseTotal time.Duration `json:"pause_total_ns"`
+	// fraction of CPU time spent on GC since the process started.
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+func snapshot(m *runtime.MemStats) MemSnapshot {
+	return MemSnapshot{
+		Alloc:         m.Alloc,
+		TotalAlloc:    m.TotalAlloc,
+		Sys:           m.Sys,
+		HeapAlloc:     m.HeapAlloc,
+		HeapSys:       m.HeapSys,
+		HeapIdle:      m.HeapIdle,
+		HeapInuse:     m.HeapInuse,
+		HeapReleased:  m.HeapReleased,
+		HeapObjects:   m.HeapObjects,
+		Mallocs:       m.Mallocs,
+		Frees:         m.Frees,
+		StackInuse:    m.StackInuse,
+		NextGC:        m.NextGC,
+		NumGC:         m.NumGC,
+		PauseTotal:    time.Duration(m.PauseTotalNs),
+		GCCPUFraction: m.GCCPUFraction,
+	}
+}
+
+// How many recent GC pauses took up to UpTo (and longer than the previous bucket's UpTo). The
+// last bucket, with UpTo zero, counts longer pauses.
+type PauseBucket struct {
+	UpTo  time.Duration `json:"up_to_ns"`
+	Count int           `json:"count"`
+}
+
+var pauseBuckets = []time.Duration{
+	100 * time.Microsecond, 500 * time.Microsecond, time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+}
+
+// Buckets the recent pauses the runtime keeps, up to 256 of them.
+func pauseHistogram(m *runtime.MemStats) []PauseBucket {
+	res := make([]PauseBucket, len(pauseBuckets)+1)
+	for i, upTo := range pauseBuckets {
+		res[i].UpTo = upTo
+	}
+	n := m.NumGC
+	if n > uint32(len(m.PauseNs)) {
+		n = uint32(len(m.PauseNs))
+	}
+	for i := uint32(0); i < n; i++ {
+		// PauseNs is a circular buffer, with the most recent pause at (NumGC+255)%256.
+		pause := time.Duration(m.PauseNs[(m.NumGC-1-i)%uint32(len(m.PauseNs))])
+		b := 0
+		for b < len(pauseBuckets) && pause > pauseBuckets[b] {
+			b++
+		}
+		res[b].Count++
+	}
+	return res
+}
+
+type GCReport struct {
+	Before MemSnapshot `json:"before"`
+	After  MemSnapshot `json:"after"`
+	// how long the collection took, including returning memory to the OS if Freed.
+	Took time.Duration `json:"took_ns"`
+	// the collection's own stop-the-world pause.
+	Pause time.Duration `json:"pause_ns"`
+	// whether the server was paused around the collection.
+	Paused bool `json:"paused"`
+	// whether memory was returned to the OS, with debug.FreeOSMemory.
+	Freed bool `json:"freed"`
+	// recent pauses, including this collection's.
+	Pauses []PauseBucket `json:"pauses"`
+}
+
+// Runs a GC and reports its effect. If pause is true, the server is paused around it, so that it
+// is unregistered while collecting. If free is true, memory is also returned to the OS.
+func (a *Adminz) collect(pause, free bool) *GCReport {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	res := &GCReport{Before: snapshot(&m), Paused: pause, Freed: free}
+
+	if pause {
+		a.Lock()
+		defer a.Unlock()
+		if a.doPause() {
+			defer a.doResume()
+		}
+	}
+	start := time.Now()
+	if free {
+		debug.FreeOSMemory()
+	} else {
+		runtime.GC()
+	}
+	res.Took = time.Since(start)
+
+	runtime.ReadMemStats(&m)
+	res.After = snapshot(&m)
+	if m.NumGC > 0 {
+		res.Pause = time.Duration(m.PauseNs[(m.NumGC+255)%256])
+	}
+	res.Pauses = pauseHistogram(&m)
+	return res
+}
+
+// Sizes in bytes, as KiB, MiB etc.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatUint(n, 10) + " B"
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type gcRow struct {
+	Name          string
+	Before, After string
+}
+
+func (r *GCReport) rows() []gcRow {
+	b, a := &r.Before, &r.After
+	bytes := func(name string, before, after uint64) gcRow {
+		return gcRow{name, formatBytes(before), formatBytes(after)}
+	}
+	count := func(name string, before, after uint64) gcRow {
+		return gcRow{name, strconv.FormatUint(before, 10), strconv.FormatUint(after, 10)}
+	}
+	return []gcRow{
+		bytes("Alloc", b.Alloc, a.Alloc),
+		bytes("TotalAlloc", b.TotalAlloc, a.TotalAlloc),
+		bytes("Sys", b.Sys, a.Sys),
+		bytes("HeapAlloc", b.HeapAlloc, a.HeapAlloc),
+		bytes("HeapSys", b.HeapSys, a.HeapSys),
+		bytes("HeapIdle", b.HeapIdle, a.HeapIdle),
+		bytes("HeapInuse", b.HeapInuse, a.HeapInuse),
+		bytes("HeapReleased", b.HeapReleased, a.HeapReleased),
+		count("HeapObjects", b.HeapObjects, a.HeapObjects),
+		count("Mallocs", b.Mallocs, a.Mallocs),
+		count("Frees", b.Frees, a.Frees),
+		bytes("StackInuse", b.StackInuse, a.StackInuse),
+		bytes("NextGC", b.NextGC, a.NextGC),
+		count("NumGC", uint64(b.NumGC), uint64(a.NumGC)),
+		{"PauseTotal", b.PauseTotal.String(), a.PauseTotal.String()},
+		{"GCCPUFraction", fmt.Sprintf("%.4f%%", 100*b.GCCPUFraction), fmt.Sprintf("%.4f%%", 100*a.GCCPUFraction)},
+	}
+}
+
+var gcTemplate = template.Must(template.New("gc").Parse(`<p>Collected in {{.Took}}, with a {{.Pause}} pause
+{{- if .Paused}}, while the server was paused{{end}}
+{{- if .Freed}}, returning memory to the OS{{end}}.</p>
+<table>
+<tr><th></th><th>before</th><th>after</th></tr>
+{{range .Rows}}<tr><td>{{.Name}}</td><td>{{.Before}}</td><td>{{.After}}</td></tr>
+{{end}}</table>
+<h2>Recent pauses</h2>
+<table>
+{{range .Pauses}}<tr><td>{{if .UpTo}}&le; {{.UpTo}}{{else}}longer{{end}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<p><a href="?pause=true">collect while paused</a> | <a href="?free=true">collect and free OS memory</a> | <a href="?profile=heap">download heap profile</a></p>`))
+
+// Runs a GC and reports memory use before and after, as HTML or JSON (see Render). Takes options:
+//
+//	pause=true    pauses the server around the collection
+//	free=true     also returns freed memory to the OS
+//	profile=heap  downloads a heap profile, taken after the collection, instead of the report
+func (a *Adminz) gcHandler(w http.ResponseWriter, r *http.Request) {
+	pause, _ := strconv.ParseBool(r.FormValue("pause"))
+	free, _ := strconv.ParseBool(r.FormValue("free"))
+	report := a.collect(pause, free)
+
+	if r.FormValue("profile") == "heap" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="heap"`)
+		pprof.Lookup("heap").WriteTo(w, 0)
+		return
+	}
+
+	Render(w, r, "gc", report, func(w io.Writer) {
+		gcTemplate.Execute(w, struct {
+			*GCReport
+			Rows []gcRow
+		}{report, report.rows()})
+	})
+}
@@ -0,0 +1,107 @@
+package adminz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Killfile describes a killfile that is pausing the server. A killfile may be empty, or may
+// explain itself with lines like:
+//
+//	reason: deploying new config
+//	expires: 2016-10-20T15:04:05Z
+//
+// where expires is either an RFC3339 time or a duration, eg "30m", after the file was last
+// modified. Any other text is taken as the reason. Once expired, a killfile is ignored.
+type Killfile struct {
+	Path    string    `json:"path"`
+	Reason  string    `json:"reason,omitempty"`
+	Expires time.Time `json:"expires"`
+	// when the file was last modified.
+	Since time.Time `json:"since"`
+}
+
+func (k *Killfile) String() string {
+	if d := k.describe(); d != "" {
+		return k.Path + ": " + d
+	}
+	return k.Path
+}
+
+// The reason and expiry, if any.
+func (k *Killfile) describe() string {
+	s := k.Reason
+	if !k.Expires.IsZero() {
+		s = strings.TrimSpace(s + " (until " + k.Expires.Format(time.RFC3339) + ")")
+	}
+	return s
+}
+
+func (k *Killfile) expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// Reads the killfile at path, returning nil if there is none. A killfile that exists but can't be
+// read still counts.
+func readKillfile(path string) *Killfile {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		logger.Warn("unable to read killfile", "path", path, "err", err)
+	}
+	return parseKillfile(path, info.ModTime(), string(contents))
+}
+
+func parseKillfile(path string, since time.Time, contents string) *Killfile {
+	k := &Killfile{Path: path, Since: since}
+	var reason []string
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "reason:"):
+			reason = append(reason, strings.TrimSpace(strings.TrimPrefix(line, "reason:")))
+		case strings.HasPrefix(line, "expires:"):
+			expires := strings.TrimSpace(strings.TrimPrefix(line, "expires:"))
+			if t, err := time.Parse(time.RFC3339, expires); err == nil {
+				k.Expires = t
+			} else if d, err := time.ParseDuration(expires); err == nil {
+				k.Expires = since.Add(d)
+			} else {
+				// an unparseable expiry can't be allowed to end a pause early, so it's just ignored.
+				logger.Warn("ignoring bad expiry in killfile", "path", path, "expires", expires)
+			}
+		default:
+			reason = append(reason, line)
+		}
+	}
+	k.Reason = strings.Join(reason, " ")
+	return k
+}
+
+// The directories containing paths, each listed once.
+func killfileDirs(paths []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range paths {
+		if dir := filepath.Dir(p); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Notifies of changes in watched directories. Events is closed if watching stops working.
+type dirWatcher interface {
+	Events() <-chan struct{}
+	Close() error
+}
@@ -0,0 +1,118 @@
+package adminz
+
+import (
+	"strconv"
+	"time"
+)
+
+// State is where a server is in its lifecycle:
+//
+//	starting -> warming -> serving <-> draining -> stopped
+//
+// A server is warming from Start until its warm-ups complete, then serving unless a killfile or
+// an HTTP pause holds it draining. Stop drains, then stops, for good.
+type State int
+
+const (
+	Starting State = iota
+	Warming
+	Serving
+	Draining
+	Stopped
+)
+
+var stateNames = []string{"starting", "warming", "serving", "draining", "stopped"}
+
+func (s State) String() string {
+	if s < Starting || s > Stopped {
+		return "State(" + strconv.Itoa(int(s)) + ")"
+	}
+	return stateNames[s]
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for i, name := range stateNames {
+		if string(text) == name {
+			*s = State(i)
+			return nil
+		}
+	}
+	return &stateError{string(text)}
+}
+
+type stateError struct{ name string }
+
+func (e *stateError) Error() string { return "unknown state " + strconv.Quote(e.name) }
+
+// Warmup adds a function that must complete, without error, before the server starts serving, eg
+// to fill caches or open connections. Warm-ups run, in order, in the background after Start.
+func (a *Adminz) Warmup(warmup func() error) *Adminz {
+	a.warmups = append(a.warmups, warmup)
+	return a
+}
+
+// OnState adds a hook called, with the previous state, whenever the server enters state. Hooks
+// are called while adminz is locked, so must not call back into it.
+func (a *Adminz) OnState(state State, hook func(from State)) *Adminz {
+	if a.hooks == nil {
+		a.hooks = make(map[State][]func(State))
+	}
+	a.hooks[state] = append(a.hooks[state], hook)
+	return a
+}
+
+// State returns where the server is in its lifecycle.
+func (a *Adminz) State() State {
+	a.Lock()
+	defer a.Unlock()
+	return a.state
+}
+
+// Internal helper to change state and call its hooks. MUST be called while holding a.Lock.
+func (a *Adminz) setState(to State) {
+	from := a.state
+	if from == to {
+		return
+	}
+	a.state = to
+	logger.Info("state changed", "from", from, "to", to)
+	for _, hook := range a.hooks[to] {
+		hook(from)
+	}
+}
+
+// Runs warm-ups, then starts serving unless paused.
+func (a *Adminz) warmup() {
+	start := time.Now()
+	for i, warmup := range a.warmups {
+		if err := warmup(); err != nil {
+			logger.Error("warm-up failed, not serving", "warmup", i, "err", err)
+			a.Lock()
+			a.warmupErr = err
+			a.Unlock()
+			return
+		}
+	}
+	if len(a.warmups) > 0 {
+		logger.Info("warmed up", "took", time.Since(start))
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if a.state != Warming {
+		// stopped while warming up.
+		return
+	}
+	if a.killfile != nil || a.hold != nil {
+		a.setState(Draining)
+		return
+	}
+	if a.onresume != nil {
+		a.onresume()
+	}
+	a.setState(Serving)
+}
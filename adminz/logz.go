@@ -0,0 +1,85 @@
+package adminz
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/foursquare/fsgo/logging"
+)
+
+// How many recent log lines /logz shows unless asked for more.
+const defaultLogzLines = 200
+
+type LoggerInfo struct {
+	Name  string        `json:"name"`
+	Level logging.Level `json:"level"`
+}
+
+type Logz struct {
+	Loggers []LoggerInfo    `json:"loggers"`
+	Recent  []logging.Entry `json:"recent"`
+}
+
+var logzTemplate = template.Must(template.New("logz").Parse(`<h2>Levels</h2>
+<table>
+{{range .Loggers}}<tr><td>{{.Name}}</td><td>{{.Level}}</td></tr>
+{{end}}</table>
+<p>POST name and level (debug, info, warn or error) to change a logger's level.</p>
+<h2>Recent</h2>
+<pre>{{range .Recent}}{{.Time.Format "2006-01-02T15:04:05.000Z07:00"}} {{.String}}
+{{end}}</pre>`))
+
+// Serves loggers' levels and recent log lines (?n=200&level=warn), and changes a logger's level
+// when POSTed name and level.
+func (a *Adminz) logzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		a.setLogLevel(w, r)
+		return
+	}
+
+	n, err := strconv.Atoi(r.FormValue("n"))
+	if err != nil || n <= 0 {
+		n = defaultLogzLines
+	}
+	min := logging.Debug
+	if l := r.FormValue("level"); l != "" {
+		if min, err = logging.ParseLevel(l); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var res Logz
+	levels := logging.Levels()
+	for _, name := range logging.Names() {
+		res.Loggers = append(res.Loggers, LoggerInfo{name, levels[name]})
+	}
+	res.Recent = logging.Recent(n, min)
+	Render(w, r, "logz", res, func(w io.Writer) {
+		logzTemplate.Execute(w, res)
+	})
+}
+
+func (a *Adminz) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	who, ok := a.authorizeChange(w, r, "Changing log levels")
+	if !ok {
+		return
+	}
+	name := r.FormValue("name")
+	level, err := logging.ParseLevel(r.FormValue("level"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prev, err := logging.SetLevel(name, level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	a.audit.add(who, "set log level", fmt.Sprintf("%s=%s (was %s)", name, level, prev))
+	logger.Warn("log level set", "by", who, "from", r.RemoteAddr, "logger", name, "level", level, "was", prev)
+	fmt.Fprintf(w, "%s=%s\n", name, level)
+}
@@ -0,0 +1,137 @@
+package adminz
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Prefix serves adminz's pages under prefix, eg "/admin" serves /admin/healthz, /admin/adminz
+// and so on.
+func (a *Adminz) Prefix(prefix string) *Adminz {
+	a.prefix = prefix
+	return a
+}
+
+// Handler serves adminz's pages, at their paths under Prefix, for serving directly or mounting
+// on a mux, eg mux.Handle("/admin/", a.Prefix("/admin").Handler()). Pages must all be added
+// before the first call to Handler or Start.
+func (a *Adminz) Handler() http.Handler {
+	a.build.Do(func() {
+		mux := http.NewServeMux()
+		a.registered = append(a.standardPages(), a.pages...)
+		for _, p := range a.registered {
+			mux.Handle(p.path, a.protect(p.path, p.handler))
+		}
+		mux.Handle("/adminz", a.protect("/adminz", http.HandlerFunc(a.indexHandler)))
+
+		var h http.Handler = mux
+		if a.prefix != "" {
+			h = http.StripPrefix(a.prefix, h)
+		}
+		a.handler = withPrefix(a.prefix, h)
+	})
+	return a.handler
+}
+
+// Paths, under Prefix, that Handler serves.
+func (a *Adminz) paths() []string {
+	res := []string{a.prefix + "/adminz"}
+	for _, p := range a.registered {
+		res = append(res, a.prefix+p.path)
+	}
+	return res
+}
+
+type prefixKey struct{}
+
+// Tells pages where they are mounted, so they can link to each other.
+func withPrefix(prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), prefixKey{}, prefix)))
+	})
+}
+
+// PathPrefix is the prefix the adminz serving r is mounted under, eg "/admin", or "" at the root.
+func PathPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(prefixKey{}).(string)
+	return prefix
+}
+
+// A path adminz has registered on a mux. A ServeMux can't unregister a handler, or register a
+// path twice, so each path is registered once, forwarding to the adminz currently mounted there,
+// if any, or serving 404s. Later mounts find it on the mux by its type.
+type mountedPath struct {
+	sync.Mutex
+	owner   *Adminz
+	handler http.Handler
+}
+
+func (m *mountedPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	h := m.handler
+	m.Unlock()
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+var (
+	mountsLock sync.Mutex
+	mounts     = make(map[*http.ServeMux]map[string]*mountedPath)
+)
+
+// Serves a's pages on mux, replacing those of any adminz mounted there before.
+func (a *Adminz) mount(mux *http.ServeMux) {
+	mountsLock.Lock()
+	defer mountsLock.Unlock()
+	byPath, ok := mounts[mux]
+	if !ok {
+		byPath = make(map[string]*mountedPath)
+		mounts[mux] = byPath
+	}
+	handler := a.Handler()
+	for _, path := range a.paths() {
+		m, ok := byPath[path]
+		if !ok {
+			// only a pattern without a method, such as a mountedPath's, conflicts with registering
+			// path, so look it up with a method no other pattern has.
+			if h, pattern := mux.Handler(&http.Request{Method: "ADMINZ", URL: &url.URL{Path: path}}); pattern != path {
+				m = &mountedPath{}
+				mux.Handle(path, m)
+			} else if m, ok = h.(*mountedPath); !ok {
+				// eg /debug/vars, which importing expvar registers on the default mux.
+				logger.Warn("not mounting adminz page, path already in use", "path", path)
+				continue
+			}
+			byPath[path] = m
+		}
+		m.Lock()
+		m.owner, m.handler = a, handler
+		m.Unlock()
+	}
+	a.mountedOn = mux
+}
+
+// Stops serving a's pages on the mux it was mounted on. Once nothing is mounted on the mux, it is
+// forgotten, so the mux can be garbage collected; its paths are found again if mounted on later.
+func (a *Adminz) unmount() {
+	mountsLock.Lock()
+	defer mountsLock.Unlock()
+	inUse := false
+	for _, m := range mounts[a.mountedOn] {
+		m.Lock()
+		if m.owner == a {
+			m.owner, m.handler = nil, nil
+		}
+		inUse = inUse || m.owner != nil
+		m.Unlock()
+	}
+	if !inUse {
+		delete(mounts, a.mountedOn)
+	}
+	a.mountedOn = nil
+}
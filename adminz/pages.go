@@ -0,0 +1,102 @@
+package adminz
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Pages from other packages, such as thriftrpc.CallStats or report.Recorder, describe themselves
+// for the /adminz index by implementing this.
+type describer interface {
+	AdminDescription() string
+}
+
+// The pages Start registers, other than those added with Handle or Page.
+func (a *Adminz) standardPages() []page {
+	pages := []page{
+		{"/healthz", "OK if the server is healthy and not paused, otherwise 503 and why", http.HandlerFunc(a.healthzHandler)},
+		{"/health", "Same as /healthz", http.HandlerFunc(a.healthzHandler)},
+		{"/servicez", "Build, runtime and service information, as JSON", http.HandlerFunc(a.ServicezHandler)},
+		{"/quitquitquit", "POST with confirm=true to pause the server, then exit", http.HandlerFunc(a.quitHandler)},
+		{"/abortabortabort", "POST with confirm=true to exit immediately", http.HandlerFunc(a.abortHandler)},
+		{"/gc", "Runs a garbage collection and reports memory use before and after (?pause=true, ?free=true, ?profile=heap)", http.HandlerFunc(a.gcHandler)},
+		{"/auditz", "Who paused and resumed the server, or changed flags, and when", http.HandlerFunc(a.auditHandler)},
+		{"/logz", "Log levels and recent log lines; POST name and level to change a level", http.HandlerFunc(a.logzHandler)},
+		{"/flagz", "Runtime-tunable flags; POST name and value to change one", http.HandlerFunc(a.flagzHandler)},
+	}
+	if a.controlAuth != nil {
+		pages = append(pages,
+			page{"/pausez", "POST to pause until resumed (?reason=)", a.controlHandler("pause")},
+			page{"/resumez", "POST to lift a pause made over HTTP", a.controlHandler("resume")},
+			page{"/maintenancez", "POST to pause for a maintenance window (?duration=30m&reason=)", a.controlHandler("maintenance")},
+		)
+	}
+	if a.debug != nil {
+		pages = append(pages, a.debugPages()...)
+	}
+	return pages
+}
+
+type PageInfo struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+var indexTemplate = template.Must(template.New("adminz").Parse(`<table>
+{{range .}}<tr><td><a href="{{.Path}}">{{.Path}}</a></td><td>{{.Description}}</td></tr>
+{{end}}</table>`))
+
+func (a *Adminz) indexHandler(w http.ResponseWriter, r *http.Request) {
+	pages := make([]PageInfo, len(a.registered))
+	for i, p := range a.registered {
+		pages[i] = PageInfo{a.prefix + p.path, p.description}
+	}
+	Render(w, r, "adminz", pages, func(w io.Writer) {
+		indexTemplate.Execute(w, pages)
+	})
+}
+
+// WantsJSON is true if a request asks for JSON, with ?format=json or an Accept header.
+func WantsJSON(r *http.Request) bool {
+	return r.FormValue("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; vertical-align: top; }
+tr:nth-child(even) { background: #f4f4f4; }
+pre { font-size: 12px; }
+</style></head>
+<body><h1>{{.Title}}</h1>
+<p><a href="{{.Prefix}}/adminz">adminz</a> | <a href="?format=json">json</a></p>
+`))
+
+// Render writes data as JSON if the request asks for it (see WantsJSON), and otherwise as an HTML
+// page titled title, with body writing the page's content. If body is nil, data is shown as
+// indented JSON. Admin pages from other packages use it to render consistently.
+func Render(w http.ResponseWriter, r *http.Request, title string, data interface{}, body func(w io.Writer)) {
+	if WantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pageTemplate.Execute(w, struct{ Title, Prefix string }{title, PathPrefix(r)})
+	if body != nil {
+		body(w)
+	} else {
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		io.WriteString(w, "<pre>"+template.HTMLEscapeString(string(b))+"</pre>")
+	}
+	io.WriteString(w, "\n</body></html>\n")
+}
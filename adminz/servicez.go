@@ -0,0 +1,97 @@
+package adminz
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// When the process started, or near enough: when this package was initialized.
+var processStart = time.Now()
+
+// The envelope /servicez returns, around whatever the Servicez function produces.
+type ServiceInfo struct {
+	Build      BuildInfo   `json:"build"`
+	GoVersion  string      `json:"go_version"`
+	StartTime  time.Time   `json:"start_time"`
+	Uptime     string      `json:"uptime"`
+	Hostname   string      `json:"hostname"`
+	Pid        int         `json:"pid"`
+	Cmdline    []string    `json:"cmdline"`
+	GOMAXPROCS int         `json:"gomaxprocs"`
+	State      PauseState  `json:"state"`
+	Service    interface{} `json:"service"`
+}
+
+// What the binary's embedded build info says about how it was built. Fields are empty if it was
+// built without module or VCS information, eg by `go run` or from outside a repository.
+type BuildInfo struct {
+	Path       string    `json:"path,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Revision   string    `json:"revision,omitempty"`
+	RevisionAt time.Time `json:"revision_time"`
+	Modified   bool      `json:"modified"`
+}
+
+// Where the server is in its lifecycle, and whether it is paused, and by what.
+type PauseState struct {
+	State    State     `json:"state"`
+	Running  bool      `json:"running"`
+	Killfile *Killfile `json:"killfile,omitempty"`
+	Hold     string    `json:"hold,omitempty"`
+}
+
+func readBuildInfo() BuildInfo {
+	var res BuildInfo
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return res
+	}
+	res.Path = info.Main.Path
+	res.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			res.Revision = s.Value
+		case "vcs.time":
+			res.RevisionAt, _ = time.Parse(time.RFC3339, s.Value)
+		case "vcs.modified":
+			res.Modified = s.Value == "true"
+		}
+	}
+	return res
+}
+
+// The build info doesn't change, so is only read once.
+var buildInfo = readBuildInfo()
+
+func (a *Adminz) pauseState() PauseState {
+	a.Lock()
+	defer a.Unlock()
+	res := PauseState{State: a.state, Running: a.state == Serving, Killfile: a.killfile}
+	if a.hold != nil {
+		res.Hold = a.hold.String()
+	}
+	return res
+}
+
+func (a *Adminz) serviceInfo() *ServiceInfo {
+	hostname, _ := os.Hostname()
+	var service interface{} = &EmptyStruct{}
+	if a.servicez != nil {
+		service = a.servicez()
+	}
+	return &ServiceInfo{
+		Build:      buildInfo,
+		GoVersion:  runtime.Version(),
+		StartTime:  processStart,
+		Uptime:     time.Since(processStart).Round(time.Second).String(),
+		Hostname:   hostname,
+		Pid:        os.Getpid(),
+		Cmdline:    os.Args,
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		State:      a.pauseState(),
+		Service:    service,
+	}
+}
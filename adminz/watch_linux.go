@@ -0,0 +1,65 @@
+package adminz
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Watches directories with inotify.
+type inotifyWatcher struct {
+	f      *os.File
+	events chan struct{}
+}
+
+func newDirWatcher(dirs []string) (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+			syscall.Close(fd)
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+	}
+	// as a non-blocking os.File, reads go through the runtime poller, so Close interrupts them.
+	w := &inotifyWatcher{os.NewFile(uintptr(fd), "inotify"), make(chan struct{}, 1)}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.events)
+	buf := make([]byte, 4096)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+			// the directory itself went away, so nothing more will be seen in it.
+			if e.Mask&syscall.IN_IGNORED != 0 {
+				return
+			}
+			i += syscall.SizeofInotifyEvent + int(e.Len)
+		}
+		// which file changed doesn't matter: every killfile is re-checked anyway.
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package adminz
+
+import "errors"
+
+func newDirWatcher(dirs []string) (dirWatcher, error) {
+	return nil, errors.New("filesystem notifications are not supported on this platform")
+}
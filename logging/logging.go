@@ -0,0 +1,210 @@
+// Package logging provides leveled, structured logging for fsgo packages, with per-logger levels
+// that can be changed at runtime (eg on adminz's /logz) and a buffer of recent lines.
+//
+// Each package logs through its own named Logger:
+//
+//	var logger = logging.New("discovery")
+//
+//	logger.Info("registered", "service", name, "id", id)
+//
+// which writes `INFO [discovery] registered service=foo id=1234` through the standard log package,
+// so log.SetOutput and log.SetFlags still apply.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "Level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	parsed, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// ParseLevel parses a level name, eg "debug" or "WARN".
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// The level new loggers start at.
+const DefaultLevel = Info
+
+type Logger struct {
+	name  string
+	level int32
+}
+
+var (
+	loggersLock sync.Mutex
+	loggers     = make(map[string]*Logger)
+)
+
+// New returns the logger with the given name, creating it if need be.
+func New(name string) *Logger {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	if l, ok := loggers[name]; ok {
+		return l
+	}
+	l := &Logger{name: name, level: int32(DefaultLevel)}
+	loggers[name] = l
+	return l
+}
+
+// Levels returns the level of every logger, by name.
+func Levels() map[string]Level {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	res := make(map[string]Level, len(loggers))
+	for name, l := range loggers {
+		res[name] = l.Level()
+	}
+	return res
+}
+
+// Names returns the names of every logger, sorted.
+func Names() []string {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	res := make([]string, 0, len(loggers))
+	for name := range loggers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// SetLevel sets the level of the named logger, returning the previous level, or an error if there
+// is no such logger.
+func SetLevel(name string, level Level) (Level, error) {
+	loggersLock.Lock()
+	l, ok := loggers[name]
+	loggersLock.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown logger %q", name)
+	}
+	return Level(atomic.SwapInt32(&l.level, int32(level))), nil
+}
+
+func (l *Logger) Name() string {
+	return l.name
+}
+
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+// Enabled is true if messages at level would be logged, so expensive fields can be skipped.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Debug, Info, Warn and Error log msg followed by keyvals, which alternate between keys and
+// values, eg logger.Warn("retrying", "attempt", 2, "err", err).
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(Debug, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(Info, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(Warn, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(Error, msg, keyvals) }
+
+// A key-value pair logged with a message.
+type Field struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   Level     `json:"level"`
+	Logger  string    `json:"logger"`
+	Message string    `json:"message"`
+	Fields  []Field   `json:"fields,omitempty"`
+}
+
+// The entry as written to the log, without the time, which the log package adds.
+func (e *Entry) String() string {
+	var b strings.Builder
+	b.WriteString(e.Level.String())
+	b.WriteString(" [")
+	b.WriteString(e.Logger)
+	b.WriteString("] ")
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(quote(f.Value))
+	}
+	return b.String()
+}
+
+// Quotes values that would otherwise be ambiguous.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func fields(keyvals []interface{}) []Field {
+	if len(keyvals) == 0 {
+		return nil
+	}
+	res := make([]Field, 0, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 == len(keyvals) {
+			// a key without a value is a mistake, but the value is more useful logged than dropped.
+			res = append(res, Field{"!BADKEY", key})
+			break
+		}
+		res = append(res, Field{key, fmt.Sprint(keyvals[i+1])})
+	}
+	return res
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	e := Entry{time.Now(), level, l.name, msg, fields(keyvals)}
+	recent.add(e)
+	// skips log, and Debug, Info etc, so Lshortfile reports the caller.
+	log.Output(3, e.String())
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureOutput(f func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+	f()
+	return buf.String()
+}
+
+func TestLevels(t *testing.T) {
+	l := New("test-levels")
+	assert.Equal(t, l, New("test-levels"), "loggers are shared by name")
+	assert.Equal(t, Info, l.Level())
+
+	out := captureOutput(func() {
+		l.Debug("hidden")
+		l.Info("shown", "key", "value", "spaced", "two words", "empty", "")
+		l.Warn("odd", "dangling")
+	})
+	assert.Equal(t, `INFO [test-levels] shown key=value spaced="two words" empty=""
+WARN [test-levels] odd !BADKEY=dangling
+`, out)
+
+	prev, err := SetLevel("test-levels", Debug)
+	assert.Nil(t, err)
+	assert.Equal(t, Info, prev)
+	assert.True(t, l.Enabled(Debug))
+	assert.Equal(t, "DEBUG [test-levels] now shown\n", captureOutput(func() { l.Debug("now shown") }))
+	assert.Equal(t, Debug, Levels()["test-levels"])
+	assert.Contains(t, Names(), "test-levels")
+
+	_, err = SetLevel("no-such-logger", Debug)
+	assert.NotNil(t, err)
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"warn", "WARN", "Warn"} {
+		l, err := ParseLevel(s)
+		assert.Nil(t, err)
+		assert.Equal(t, Warn, l)
+	}
+	_, err := ParseLevel("loud")
+	assert.NotNil(t, err)
+
+	var l Level
+	assert.Nil(t, l.UnmarshalText([]byte("error")))
+	assert.Equal(t, Error, l)
+}
+
+func TestRecent(t *testing.T) {
+	l := New("test-recent")
+	captureOutput(func() {
+		for i := 0; i < RecentSize+10; i++ {
+			l.Info("line", "i", i)
+		}
+		l.Error("failed")
+	})
+
+	all := Recent(RecentSize*2, Debug)
+	assert.Len(t, all, RecentSize, "the buffer is bounded")
+	assert.Equal(t, "failed", all[0].Message)
+	assert.Equal(t, []Field{{"i", "1009"}}, all[1].Fields)
+
+	errors := Recent(10, Error)
+	assert.Len(t, errors, 1)
+	assert.True(t, strings.HasPrefix(errors[0].String(), "ERROR [test-recent] failed"))
+}
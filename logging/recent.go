@@ -0,0 +1,42 @@
+package logging
+
+import "sync"
+
+// How many entries the recent buffer holds.
+const RecentSize = 1000
+
+// A ring buffer of the most recently logged entries.
+type ring struct {
+	sync.Mutex
+	entries []Entry
+	// where the next entry goes, once the buffer is full.
+	next int
+}
+
+var recent ring
+
+func (r *ring) add(e Entry) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.entries) < RecentSize {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % RecentSize
+}
+
+// Recent returns up to n of the most recently logged entries at or above level, most recent first.
+func Recent(n int, level Level) []Entry {
+	recent.Lock()
+	defer recent.Unlock()
+	var res []Entry
+	for i := 0; i < len(recent.entries) && len(res) < n; i++ {
+		// walk backwards from the newest entry, which is just before next.
+		e := recent.entries[(recent.next-1-i+2*len(recent.entries))%len(recent.entries)]
+		if e.Level >= level {
+			res = append(res, e)
+		}
+	}
+	return res
+}
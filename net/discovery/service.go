@@ -1,21 +1,35 @@
 package discovery
 
 import (
-	"log"
+	"sync"
 	"time"
 
 	"github.com/curator-go/curator"
+	"github.com/foursquare/fsgo/logging"
+	"github.com/samuel/go-zookeeper/zk"
 )
 
+var logger = logging.New("discovery")
+
 type ServiceDiscovery struct {
 	client curator.CuratorFramework
 
-	// Cache of watched services
+	// Cache of watched services, updated by the tree cache while watching. Only read it while
+	// holding servicesLock, or use Provider.
 	Services map[string][]*ServiceInstance
+	// guards Services. Separate from the Mutex below, so reads don't wait on ZooKeeper.
+	servicesLock sync.RWMutex
+
+	// guards maintain and paused, and serializes changes to registrations, so that re-registering
+	// after a reconnect can't race with pausing.
+	sync.Mutex
 
 	// Maintained service registrations
 	maintain map[string]*ServiceInstance
 
+	// Paused registrations, which are kept unregistered, even across reconnects, until resumed.
+	paused map[string]*ServiceInstance
+
 	tree *TreeCache
 
 	// path under which to read/create registrations (/base/servicename/instance-id)
@@ -52,6 +66,7 @@ func NewServiceDiscovery(client Conn, basePath string) *ServiceDiscovery {
 	s.client = client
 	s.basePath = basePath
 	s.maintain = make(map[string]*ServiceInstance)
+	s.paused = make(map[string]*ServiceInstance)
 	s.serializer = &JsonInstanceSerializer{}
 	s.connChanges = make(chan bool, 10)
 	s.Services = make(map[string][]*ServiceInstance)
@@ -86,7 +101,7 @@ func (s *ServiceDiscovery) maintainConn() {
 			break
 		}
 		if c && c != prev {
-			log.Println("Reconnected. Re-registering services.")
+			logger.Info("reconnected, re-registering services")
 			s.ReregisterAll()
 		}
 		prev = c
@@ -102,6 +117,14 @@ func (s *ServiceDiscovery) pathForInstance(name, id string) string {
 }
 
 func (s *ServiceDiscovery) Register(service *ServiceInstance) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.paused, service.Id)
+	return s.register(service)
+}
+
+// Internal helper to create a registration. MUST be called while holding s.Lock.
+func (s *ServiceDiscovery) register(service *ServiceInstance) error {
 	b, err := s.serializer.Serialize(service)
 	if err != nil {
 		return err
@@ -115,9 +138,9 @@ func (s *ServiceDiscovery) Register(service *ServiceInstance) error {
 	}
 
 	for i := 0; i < 3; i++ {
-		log.Printf("Creating %s registration %s (attempt %d): %s\n", service.Name, service.Spec(), i+1, p)
+		logger.Info("creating registration", "service", service.Name, "spec", service.Spec(), "attempt", i+1, "path", p)
 		_, err = s.client.Create().CreatingParentsIfNeeded().WithMode(m).ForPathWithData(p, b)
-		if err == nil {
+		if err == nil || err == zk.ErrNodeExists {
 			s.maintain[service.Id] = service
 			return nil
 		}
@@ -127,16 +150,51 @@ func (s *ServiceDiscovery) Register(service *ServiceInstance) error {
 }
 
 func (s *ServiceDiscovery) Unregister(service *ServiceInstance) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.paused, service.Id)
+	delete(s.maintain, service.Id)
+	return s.remove(service)
+}
+
+// Internal helper to delete a registration. MUST be called while holding s.Lock.
+func (s *ServiceDiscovery) remove(service *ServiceInstance) error {
 	p := s.pathForInstance(service.Name, service.Id)
+	logger.Info("deleting registration", "service", service.Name, "spec", service.Spec(), "path", p)
+	if err := s.client.Delete().ForPath(p); err != nil && err != zk.ErrNoNode {
+		return err
+	}
+	return nil
+}
+
+// Pause removes a registration, eg while a killfile is present, until Resume is called. Unlike
+// Unregister, the registration is remembered: it is not re-created on reconnect, and if the
+// delete fails, eg because ZooKeeper is unreachable, it is retried on reconnect so a registration
+// that survived the disconnect doesn't linger.
+func (s *ServiceDiscovery) Pause(service *ServiceInstance) error {
+	s.Lock()
+	defer s.Unlock()
 	delete(s.maintain, service.Id)
+	s.paused[service.Id] = service
+	return s.remove(service)
+}
 
-	log.Printf("Deleting %s registration %s: %s\n", service.Name, service.Spec(), p)
-	return s.client.Delete().ForPath(p)
+// Resume re-creates a registration removed by Pause.
+func (s *ServiceDiscovery) Resume(service *ServiceInstance) error {
+	return s.Register(service)
 }
 
+// Re-creates maintained registrations, and removes paused ones again, eg after reconnecting.
 func (s *ServiceDiscovery) ReregisterAll() error {
+	s.Lock()
+	defer s.Unlock()
+	for _, i := range s.paused {
+		if err := s.remove(i); err != nil {
+			return err
+		}
+	}
 	for _, i := range s.maintain {
-		if err := s.Register(i); err != nil {
+		if err := s.register(i); err != nil {
 			return err
 		}
 	}
@@ -144,11 +202,15 @@ func (s *ServiceDiscovery) ReregisterAll() error {
 }
 
 func (s *ServiceDiscovery) UnregisterAll() error {
+	s.Lock()
+	defer s.Unlock()
 	for _, i := range s.maintain {
-		if err := s.Unregister(i); err != nil {
+		delete(s.maintain, i.Id)
+		if err := s.remove(i); err != nil {
 			return err
 		}
 	}
+	s.paused = make(map[string]*ServiceInstance)
 	return nil
 }
 
@@ -159,6 +221,8 @@ type ServiceDiscoveryInstanceProvider struct {
 }
 
 func (s *ServiceDiscoveryInstanceProvider) GetAllInstances() ([]*ServiceInstance, error) {
+	s.disco.servicesLock.RLock()
+	defer s.disco.servicesLock.RUnlock()
 	return s.disco.Services[s.name], nil
 }
 
@@ -165,3 +165,49 @@ func TestRoundTrip(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 
 }
+
+func TestPauseResume(t *testing.T) {
+	z := getTestCluster(t)
+	c := getTestClient(t, z)
+	defer z.Stop()
+
+	base := fmt.Sprintf("/foobar/paused-%d", time.Now().UnixNano())
+	s := NewServiceDiscovery(c, base)
+
+	reg := NewSimpleServiceInstance("baz", "a", 8080)
+	if err := s.Register(reg); err != nil {
+		t.Fatal("failed to register: ", err)
+	}
+
+	if err := s.Pause(reg); err != nil {
+		t.Fatal("failed to pause: ", err)
+	}
+	if ls, _ := c.GetChildren().ForPath(s.pathForName("baz")); len(ls) != 0 {
+		t.Fatal("pause did not remove registration:", ls)
+	}
+
+	// as if reconnecting while paused.
+	if err := s.ReregisterAll(); err != nil {
+		t.Fatal("failed to reregister: ", err)
+	}
+	if ls, _ := c.GetChildren().ForPath(s.pathForName("baz")); len(ls) != 0 {
+		t.Fatal("reconnecting resurrected paused registration:", ls)
+	}
+
+	if err := s.Resume(reg); err != nil {
+		t.Fatal("failed to resume: ", err)
+	}
+	if ls, _ := c.GetChildren().ForPath(s.pathForName("baz")); len(ls) != 1 {
+		t.Fatal("resume did not re-register:", ls)
+	}
+
+	// reconnecting with the registration still in place should leave it be.
+	if err := s.ReregisterAll(); err != nil {
+		t.Fatal("failed to reregister: ", err)
+	}
+	if ls, _ := c.GetChildren().ForPath(s.pathForName("baz")); len(ls) != 1 {
+		t.Fatal("reregistering lost registration:", ls)
+	}
+
+	s.UnregisterAll()
+}
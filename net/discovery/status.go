@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/foursquare/fsgo/adminz"
+)
+
+// The state of a ServiceDiscovery, as shown on its admin page.
+type Status struct {
+	BasePath   string                        `json:"basePath"`
+	Registered []*ServiceInstance            `json:"registered"`
+	Paused     []*ServiceInstance            `json:"paused"`
+	Watched    map[string][]*ServiceInstance `json:"watched"`
+}
+
+func (s *ServiceDiscovery) Status() *Status {
+	res := &Status{BasePath: s.basePath, Watched: make(map[string][]*ServiceInstance)}
+	s.Lock()
+	for _, i := range s.maintain {
+		res.Registered = append(res.Registered, i)
+	}
+	for _, i := range s.paused {
+		res.Paused = append(res.Paused, i)
+	}
+	s.Unlock()
+	sortInstances(res.Registered)
+	sortInstances(res.Paused)
+
+	s.servicesLock.RLock()
+	defer s.servicesLock.RUnlock()
+	for name, instances := range s.Services {
+		res.Watched[name] = append([]*ServiceInstance(nil), instances...)
+	}
+	return res
+}
+
+func sortInstances(l []*ServiceInstance) {
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Name != l[j].Name {
+			return l[i].Name < l[j].Name
+		}
+		return l[i].Id < l[j].Id
+	})
+}
+
+var statusTemplate = template.Must(template.New("discovery").Funcs(template.FuncMap{
+	"port": func(p *int) int {
+		if p == nil {
+			return 0
+		}
+		return *p
+	},
+}).Parse(`{{define "instances"}}<table>
+<tr><th>name</th><th>id</th><th>address</th><th>port</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Id}}</td><td>{{.Address}}</td><td>{{port .Port}}</td></tr>
+{{end}}</table>{{end}}<p>Base path: {{.BasePath}}</p>
+<h2>Registered</h2>
+{{template "instances" .Registered}}
+<h2>Paused</h2>
+{{template "instances" .Paused}}
+{{range $name, $instances := .Watched}}<h2>Watched: {{$name}}</h2>
+{{template "instances" $instances}}
+{{end}}`))
+
+// Serves the registered, paused and watched services, as HTML or JSON (see adminz.Render).
+func (s *ServiceDiscovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := s.Status()
+	adminz.Render(w, r, "discovery", status, func(w io.Writer) {
+		statusTemplate.Execute(w, status)
+	})
+}
+
+// Describes the page for the adminz index.
+func (s *ServiceDiscovery) AdminDescription() string {
+	return "Registered, paused and watched services"
+}
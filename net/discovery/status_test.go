@@ -0,0 +1,29 @@
+package discovery
+
+import (
+	"testing"
+)
+
+// Run with -race: reading the status mustn't race with the tree cache updating services.
+func TestStatusWhileWatching(t *testing.T) {
+	s := NewServiceDiscovery(nil, "/base")
+	tree := NewTreeCache(s)
+	// already known instances are read from the cache rather than ZooKeeper.
+	tree.existing["foo"] = map[string]*ServiceInstance{"a": NewSimpleServiceInstance("foo", "host", 80)}
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			tree.readInstanceList("foo", []string{"a"})
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		s.Status()
+	}
+	<-done
+
+	if watched := s.Status().Watched["foo"]; len(watched) != 1 || watched[0].Address != "host" {
+		t.Fatal("missing watched instance:", watched)
+	}
+}
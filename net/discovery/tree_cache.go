@@ -1,8 +1,6 @@
 package discovery
 
 import (
-	"log"
-
 	"github.com/curator-go/curator"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -34,7 +32,7 @@ func (t *TreeCache) processInstanceChanges() {
 		}
 		t.readAndWatch(s, "restarting")
 	}
-	log.Println("Done watching for instance changes")
+	logger.Info("done watching for instance changes")
 }
 
 func (t *TreeCache) readAndWatch(service, verb string) {
@@ -43,7 +41,7 @@ func (t *TreeCache) readAndWatch(service, verb string) {
 	children, err := t.client.GetChildren().UsingWatcher(w).ForPath(p)
 
 	if err != nil {
-		log.Printf("Error %s watch for %s: %s\n", verb, service, err)
+		logger.Error("error "+verb+" watch", "service", service, "err", err)
 	} else {
 		t.readInstanceList(service, children)
 	}
@@ -66,23 +64,25 @@ func (t *TreeCache) readInstanceList(s string, children []string) {
 		p := t.pathForInstance(s, id)
 		data, err := t.client.GetData().ForPath(p)
 		if err != nil {
-			log.Printf("Error fetching instance info for %s-%s (%s): %s\n", s, id, p, err)
+			logger.Error("error fetching instance info", "service", s, "id", id, "path", p, "err", err)
 			continue
 		}
 
 		i, err := t.serializer.Deserialize(data)
 		if err != nil {
-			log.Printf("Error decoding instance info for %s-%s (%s): %s\n", s, id, p, err)
+			logger.Error("error decoding instance info", "service", s, "id", id, "path", p, "err", err)
 			continue
 		}
 		i.Id = id // Just in case, since we treat use path for caching.
 
-		log.Printf("New instance for %s in %s (%s)\n", s, i.Spec(), id)
+		logger.Info("new instance", "service", s, "spec", i.Spec(), "id", id)
 		existing[id] = i
 		instances = append(instances, i)
 	}
 	t.existing[s] = existing
+	t.servicesLock.Lock()
 	t.Services[s] = instances
+	t.servicesLock.Unlock()
 }
 
 func (t *TreeCache) processServiceChanges() {
@@ -97,7 +97,7 @@ func (t *TreeCache) processServiceChanges() {
 
 			t.readServices(watching)
 		}
-		log.Println("Done watching for service changes")
+		logger.Info("done watching for service changes")
 	}()
 	t.readServices(watching)
 }
@@ -107,7 +107,7 @@ func (t *TreeCache) readServices(watching map[string]bool) {
 
 	children, err := t.client.GetChildren().UsingWatcher(w).ForPath(t.basePath)
 	if err != nil {
-		log.Println("Error reading service list: ", err)
+		logger.Error("error reading service list", "err", err)
 		return
 	}
 	found := make(map[string]bool)
@@ -118,6 +118,8 @@ func (t *TreeCache) readServices(watching map[string]bool) {
 			watching[i] = true
 		}
 	}
+	t.servicesLock.Lock()
+	defer t.servicesLock.Unlock()
 	for i, _ := range t.Services {
 		if !found[i] {
 			delete(t.Services, i)
@@ -0,0 +1,220 @@
+package thriftrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+type AccessLogFormat int
+
+const (
+	Logfmt AccessLogFormat = iota
+	JSON
+)
+
+// AccessLog writes a structured line per call handled by a LoggedProcessor. Failed calls, including
+// those replying with an exception declared in the IDL, are always logged, as are calls taking at
+// least SlowThreshold if it is set. Other calls are logged with probability SampleRate.
+//
+// The config fields may be changed before the log is first used, but not after.
+type AccessLog struct {
+	Format        AccessLogFormat
+	SlowThreshold time.Duration
+	// Defaults to 1, logging every call, unless SlowThreshold is set, in which case it defaults to 0.
+	SampleRate float64
+
+	sync.Mutex
+	out  io.Writer
+	rand *rand.Rand
+}
+
+// Creates an AccessLog writing logfmt lines for every call to out.
+func NewAccessLog(out io.Writer) *AccessLog {
+	return &AccessLog{Format: Logfmt, SampleRate: 1, out: out, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Creates an AccessLog writing logfmt lines to out only for failed calls and calls taking at
+// least threshold.
+func NewSlowCallLog(out io.Writer, threshold time.Duration) *AccessLog {
+	l := NewAccessLog(out)
+	l.SlowThreshold = threshold
+	l.SampleRate = 0
+	return l
+}
+
+type AccessLogEntry struct {
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	SeqId        int32     `json:"seq_id"`
+	DurationMs   float64   `json:"duration_ms"`
+	RequestSize  int       `json:"request_bytes"`
+	ResponseSize int       `json:"response_bytes"`
+	Peer         string    `json:"peer,omitempty"`
+	TraceId      string    `json:"trace_id,omitempty"`
+	Principal    string    `json:"principal,omitempty"`
+	Exception    string    `json:"exception,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+func (l *AccessLog) shouldLog(dur time.Duration, failed bool) bool {
+	if failed || (l.SlowThreshold > 0 && dur >= l.SlowThreshold) {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.rand.Float64() < l.SampleRate
+}
+
+// Logs a call if it should be logged. oprot is used to find the response size, and declared is the
+// name of the declared exception the call replied with, if any.
+func (l *AccessLog) log(method string, seqId int32, start time.Time, iprot, oprot thrift.TProtocol, err error, declared string) {
+	dur := time.Since(start)
+	if !l.shouldLog(dur, err != nil || declared != "") {
+		return
+	}
+
+	e := AccessLogEntry{Time: start, Method: method, SeqId: seqId, DurationMs: float64(dur) / float64(time.Millisecond)}
+	e.RequestSize, e.ResponseSize = -1, -1
+	if info := callInfoOf(iprot); info != nil {
+		e.Peer, e.TraceId, e.Principal, e.RequestSize = info.peer, info.traceId, info.principal, info.requestSize
+	}
+	if buf, ok := oprot.Transport().(interface {
+		Len() int
+	}); ok {
+		e.ResponseSize = buf.Len()
+	}
+	if err != nil {
+		e.Exception = fmt.Sprintf("%T", err)
+		e.Error = err.Error()
+	} else {
+		e.Exception = declared
+	}
+
+	var line []byte
+	if l.Format == JSON {
+		line, _ = json.Marshal(e)
+	} else {
+		line = e.logfmt()
+	}
+	line = append(line, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	l.out.Write(line)
+}
+
+func (e AccessLogEntry) logfmt() []byte {
+	fields := []string{
+		"time=" + e.Time.Format(time.RFC3339Nano),
+		"method=" + logfmtValue(e.Method),
+		"seq_id=" + strconv.Itoa(int(e.SeqId)),
+		"duration_ms=" + strconv.FormatFloat(e.DurationMs, 'f', 3, 64),
+		"request_bytes=" + strconv.Itoa(e.RequestSize),
+		"response_bytes=" + strconv.Itoa(e.ResponseSize),
+	}
+	if e.Peer != "" {
+		fields = append(fields, "peer="+logfmtValue(e.Peer))
+	}
+	if e.TraceId != "" {
+		fields = append(fields, "trace_id="+logfmtValue(e.TraceId))
+	}
+	if e.Principal != "" {
+		fields = append(fields, "principal="+logfmtValue(e.Principal))
+	}
+	if e.Exception != "" {
+		fields = append(fields, "exception="+logfmtValue(e.Exception))
+	}
+	if e.Error != "" {
+		fields = append(fields, "error="+logfmtValue(e.Error))
+	}
+	return []byte(strings.Join(fields, " "))
+}
+
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Watches a reply being written for an exception declared in the IDL, which a generated processor
+// writes as a field of the result other than success, without returning an error.
+type exceptionWatcher struct {
+	thrift.TProtocol
+	depth int
+	// set once a non-success struct field of the result is started.
+	inField bool
+	// the declared exception's struct name.
+	exception string
+}
+
+func (w *exceptionWatcher) WriteStructBegin(name string) error {
+	if w.depth == 1 && w.inField && w.exception == "" {
+		w.exception = name
+	}
+	w.depth++
+	return w.TProtocol.WriteStructBegin(name)
+}
+
+func (w *exceptionWatcher) WriteStructEnd() error {
+	w.depth--
+	return w.TProtocol.WriteStructEnd()
+}
+
+func (w *exceptionWatcher) WriteFieldBegin(name string, typeId thrift.TType, id int16) error {
+	if w.depth == 1 {
+		w.inField = id != 0 && typeId == thrift.STRUCT
+	}
+	return w.TProtocol.WriteFieldBegin(name, typeId, id)
+}
+
+// What a server knows about a call that the processor handling it cannot see for itself. Servers
+// attach it to the input protocol with withCallInfo.
+type callInfo struct {
+	peer        string
+	traceId     string
+	principal   string
+	requestSize int
+}
+
+type callInfoProtocol struct {
+	thrift.TProtocol
+	info *callInfo
+}
+
+func withCallInfo(p thrift.TProtocol, info *callInfo) thrift.TProtocol {
+	if info == nil {
+		return p
+	}
+	return callInfoProtocol{p, info}
+}
+
+func callInfoOf(p thrift.TProtocol) *callInfo {
+	if c, ok := p.(callInfoProtocol); ok {
+		return c.info
+	}
+	return nil
+}
+
+// Trace ids are taken from the first of these request headers that is set.
+var traceHeaders = []string{"X-Trace-Id", "X-B3-TraceId", "X-Request-Id"}
+
+func httpCallInfo(req *http.Request, size int) *callInfo {
+	info := &callInfo{peer: req.RemoteAddr, requestSize: size}
+	for _, h := range traceHeaders {
+		if v := req.Header.Get(h); v != "" {
+			info.traceId = v
+			break
+		}
+	}
+	return info
+}
@@ -0,0 +1,79 @@
+package thriftrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	l := NewAccessLog(&out)
+	l.Format = JSON
+	p := NewLoggedProcessor(newFakeProcessor("ping"), nil, false).LogCalls(l)
+	h := NewThriftOverHTTPHandler(p, nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Trace-Id", "abc123")
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	assertCall(t, recv, send, "ping")
+	call(recv, send, "nope")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var e AccessLogEntry
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &e))
+	assert.Equal(t, "ping", e.Method)
+	assert.Equal(t, int32(1), e.SeqId)
+	assert.Equal(t, "abc123", e.TraceId)
+	assert.True(t, strings.HasPrefix(e.Peer, "127.0.0.1:"))
+	assert.True(t, e.RequestSize > 0)
+	assert.True(t, e.ResponseSize > 0)
+	assert.Equal(t, "", e.Exception)
+
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &e))
+	assert.Equal(t, "nope", e.Method)
+	assert.Equal(t, "*thrift.tApplicationException", e.Exception)
+}
+
+func TestSlowCallLog(t *testing.T) {
+	processor := newFakeProcessor("ping")
+	processor["oops"] = declaredExceptionFunc{"oops", "Oops"}
+
+	for _, c := range []struct {
+		threshold time.Duration
+		method    string
+		// in the logged line, or nothing if the call shouldn't be logged.
+		expected []string
+		msg      string
+	}{
+		{time.Hour, "ping", nil, "fast successful calls should not be logged"},
+		{time.Hour, "nope", []string{`method=nope seq_id=1 `, `exception=*thrift.tApplicationException`, `error="Unknown function nope"`}, "failed calls should be logged"},
+		{time.Hour, "oops", []string{`method=oops seq_id=1 `, `exception=Oops`}, "declared exceptions should be logged"},
+		{0, "ping", nil, "successful calls should be sampled at SampleRate"},
+	} {
+		var out bytes.Buffer
+		p := NewLoggedProcessor(processor, nil, false).LogCalls(NewSlowCallLog(&out, c.threshold))
+		ts := httptest.NewServer(NewThriftOverHTTPHandler(p, nil))
+		recv, send := NewClientProts(ts.URL, false)
+		call(recv, send, c.method)
+		ts.Close()
+
+		if c.expected == nil {
+			assert.Equal(t, "", out.String(), c.msg)
+		}
+		for _, s := range c.expected {
+			assert.True(t, strings.Contains(out.String(), s), "%s: %q in %q", c.msg, s, out.String())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package thriftrpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Authenticator identifies the caller of a thrift-over-http request, returning the principal it
+// authenticated as, or an error if it could not.
+type Authenticator interface {
+	Authenticate(req *http.Request, body []byte) (principal string, err error)
+}
+
+// Authorizer decides whether an authenticated principal may call a method. Methods of
+// multiplexed services are named "service:method".
+type Authorizer interface {
+	Authorize(principal, method string) bool
+}
+
+var ErrUnauthenticated = errors.New("thriftrpc: missing or invalid credentials")
+
+// Headers used by HMACAuthenticator and HMACCredentials.
+const (
+	HMACKeyIdHeader     = "X-Thrift-Key-Id"
+	HMACTimestampHeader = "X-Thrift-Timestamp"
+	HMACSignatureHeader = "X-Thrift-Signature"
+)
+
+// HMACAuthenticator authenticates requests signed with a shared secret by HMACCredentials. The
+// principal is the id of the key used.
+type HMACAuthenticator struct {
+	keys map[string][]byte
+	// Requests signed further than this from the server's clock are rejected. Defaults to 5 minutes.
+	MaxSkew time.Duration
+}
+
+// Creates an HMACAuthenticator accepting signatures by any of keys, by key id.
+func NewHMACAuthenticator(keys map[string][]byte) *HMACAuthenticator {
+	return &HMACAuthenticator{keys, 5 * time.Minute}
+}
+
+func (a *HMACAuthenticator) Authenticate(req *http.Request, body []byte) (string, error) {
+	id := req.Header.Get(HMACKeyIdHeader)
+	key, ok := a.keys[id]
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	ts := req.Header.Get(HMACTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > a.MaxSkew || skew < -a.MaxSkew {
+		return "", ErrUnauthenticated
+	}
+	sig, err := hex.DecodeString(req.Header.Get(HMACSignatureHeader))
+	if err != nil || !hmac.Equal(sig, hmacSignature(key, ts, body)) {
+		return "", ErrUnauthenticated
+	}
+	return id, nil
+}
+
+// Signs the timestamp as well as the body, so that captured requests can't be replayed forever.
+func hmacSignature(key []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// BearerTokenAuthenticator authenticates requests with an `Authorization: Bearer <token>` header.
+type BearerTokenAuthenticator struct {
+	// token -> principal
+	tokens map[string]string
+}
+
+// Creates a BearerTokenAuthenticator accepting any of tokens, which map each token to the
+// principal it authenticates as.
+func NewBearerTokenAuthenticator(tokens map[string]string) *BearerTokenAuthenticator {
+	return &BearerTokenAuthenticator{tokens}
+}
+
+func (a *BearerTokenAuthenticator) Authenticate(req *http.Request, body []byte) (string, error) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", ErrUnauthenticated
+	}
+	given := []byte(strings.TrimPrefix(auth, "Bearer "))
+	// compare against every token, in constant time, so timing reveals nothing about them.
+	principal := ""
+	for token, p := range a.tokens {
+		if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+			principal = p
+		}
+	}
+	if principal == "" {
+		return "", ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// ClientCertAuthenticator authenticates requests made over TLS with a verified client
+// certificate. The principal is the certificate's subject common name. The server's tls.Config
+// must request and verify client certificates, eg with ClientAuth: tls.RequireAndVerifyClientCert.
+type ClientCertAuthenticator struct {
+	subjects map[string]bool
+}
+
+// Creates a ClientCertAuthenticator accepting only the given subject common names, or any
+// verified certificate if none are given.
+func NewClientCertAuthenticator(subjects ...string) *ClientCertAuthenticator {
+	a := &ClientCertAuthenticator{make(map[string]bool)}
+	for _, s := range subjects {
+		a.subjects[s] = true
+	}
+	return a
+}
+
+func (a *ClientCertAuthenticator) Authenticate(req *http.Request, body []byte) (string, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return "", ErrUnauthenticated
+	}
+	cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(a.subjects) > 0 && !a.subjects[cn] {
+		return "", ErrUnauthenticated
+	}
+	return cn, nil
+}
+
+// FirstOf authenticates with the first of authenticators to succeed, eg to accept either client
+// certs or tokens.
+func FirstOf(authenticators ...Authenticator) Authenticator {
+	return firstOf(authenticators)
+}
+
+type firstOf []Authenticator
+
+func (f firstOf) Authenticate(req *http.Request, body []byte) (string, error) {
+	for _, a := range f {
+		if p, err := a.Authenticate(req, body); err == nil {
+			return p, nil
+		}
+	}
+	return "", ErrUnauthenticated
+}
+
+// MethodPolicy is an Authorizer listing which principals may call each method. Methods not
+// listed use the entry for "*", if any, and are otherwise denied. The principal "*" allows any
+// authenticated principal.
+type MethodPolicy map[string][]string
+
+func (m MethodPolicy) Authorize(principal, method string) bool {
+	allowed, ok := m[method]
+	if !ok {
+		allowed = m["*"]
+	}
+	for _, p := range allowed {
+		if p == principal || p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps the input protocol of an authenticated call to check, once the method name has been
+// read, that the principal may call it.
+type authzProtocol struct {
+	thrift.TProtocol
+	principal string
+	authz     Authorizer
+	// set to the method name if the call was denied.
+	denied *string
+}
+
+func (p authzProtocol) ReadMessageBegin() (name string, typeId thrift.TMessageType, seqid int32, err error) {
+	name, typeId, seqid, err = p.TProtocol.ReadMessageBegin()
+	if err == nil && !p.authz.Authorize(p.principal, name) {
+		*p.denied = name
+		err = thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, p.principal+" may not call "+name)
+	}
+	return name, typeId, seqid, err
+}
+
+// CredentialProvider adds credentials to outgoing thrift-over-http requests.
+type CredentialProvider interface {
+	Apply(req *http.Request, body []byte) error
+}
+
+// HMACCredentials signs requests for an HMACAuthenticator.
+type HMACCredentials struct {
+	KeyId string
+	Key   []byte
+}
+
+func (c HMACCredentials) Apply(req *http.Request, body []byte) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HMACKeyIdHeader, c.KeyId)
+	req.Header.Set(HMACTimestampHeader, ts)
+	req.Header.Set(HMACSignatureHeader, hex.EncodeToString(hmacSignature(c.Key, ts, body)))
+	return nil
+}
+
+// BearerToken sends a token for a BearerTokenAuthenticator.
+type BearerToken string
+
+func (t BearerToken) Apply(req *http.Request, body []byte) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
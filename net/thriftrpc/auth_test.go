@@ -0,0 +1,68 @@
+package thriftrpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/foursquare/fsgo/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACAuth(t *testing.T) {
+	authn := NewHMACAuthenticator(map[string][]byte{"web": []byte("secret")})
+	authz := MethodPolicy{"ping": {"*"}, "admin": {"ops"}}
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("ping", "admin"), nil, false), nil).Auth(authn, authz))
+	defer ts.Close()
+
+	recv, send := NewClientProtsWithOptions(ts.URL, false, &ClientOptions{Credentials: HMACCredentials{"web", []byte("secret")}})
+	assertCall(t, recv, send, "ping")
+	_, err := call(recv, send, "admin")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "403"), err.Error())
+
+	recv, send = NewClientProtsWithOptions(ts.URL, false, &ClientOptions{Credentials: HMACCredentials{"web", []byte("wrong")}})
+	_, err = call(recv, send, "ping")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "401"), err.Error())
+
+	recv, send = NewClientProts(ts.URL, false)
+	_, err = call(recv, send, "ping")
+	assert.NotNil(t, err)
+}
+
+func TestBearerTokenAuth(t *testing.T) {
+	authn := FirstOf(NewClientCertAuthenticator(), NewBearerTokenAuthenticator(map[string]string{"t0k3n": "ops"}))
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("admin"), nil, false), nil).Auth(authn, MethodPolicy{"admin": {"ops"}}))
+	defer ts.Close()
+
+	recv, send := NewClientProtsWithOptions(ts.URL, true, &ClientOptions{Credentials: BearerToken("t0k3n")})
+	assertCall(t, recv, send, "admin")
+
+	recv, send = NewClientProtsWithOptions(ts.URL, true, &ClientOptions{Credentials: BearerToken("guess")})
+	_, err := call(recv, send, "admin")
+	assert.NotNil(t, err)
+}
+
+func TestMultiplexedAuth(t *testing.T) {
+	stats := report.NewRecorder()
+	mux := NewServiceMux(stats, false).Register("users", newFakeProcessor("getUser", "deleteUser"))
+	authn := NewBearerTokenAuthenticator(map[string]string{"t0k3n": "web"})
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(mux, stats).Auth(authn, MethodPolicy{"users:getUser": {"web"}}))
+	defer ts.Close()
+
+	recv, send := NewMultiplexedClientProts(ts.URL, false, "users", &ClientOptions{Credentials: BearerToken("t0k3n")})
+	assertCall(t, recv, send, "getUser")
+	_, err := call(recv, send, "deleteUser")
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.auth.denied.users_deleteUser").Count(), "service and method are metric safe")
+}
+
+func TestMethodPolicy(t *testing.T) {
+	p := MethodPolicy{"a": {"x"}, "*": {"y"}}
+	assert.True(t, p.Authorize("x", "a"))
+	assert.False(t, p.Authorize("y", "a"))
+	assert.True(t, p.Authorize("y", "b"))
+	assert.False(t, p.Authorize("x", "b"))
+	assert.False(t, MethodPolicy{}.Authorize("x", "a"))
+}
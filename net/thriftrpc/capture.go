@@ -0,0 +1,218 @@
+package thriftrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// A raw request and response captured by a ThriftOverHTTPHandler. Bodies are base64 encoded in JSON.
+type CapturedCall struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Protocol string        `json:"protocol"`
+	Duration time.Duration `json:"duration"`
+	Request  []byte        `json:"request"`
+	Response []byte        `json:"response"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Capture samples raw calls served by a ThriftOverHTTPHandler into a bounded ring buffer and,
+// optionally, a rotating file, to help reproduce production problems with Replay. It serves the
+// buffered calls as JSON lines, for download from an admin page.
+type Capture struct {
+	sampleRate float64
+
+	sync.Mutex
+	rand *rand.Rand
+	// ring buffer of captured calls; the next one is written at next.
+	calls []CapturedCall
+	next  int
+	size  int
+	file  *rotatingFile
+}
+
+// Creates a Capture keeping the last `size` calls, of which it samples a `sampleRate` fraction. A
+// size of 0 or less keeps none in memory, so calls are only captured to a file set with ToFile.
+func NewCapture(size int, sampleRate float64) *Capture {
+	return &Capture{
+		sampleRate: sampleRate,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		size:       size,
+	}
+}
+
+// ToFile additionally appends every captured call, as a JSON line, to the file at path. Once the
+// file exceeds maxBytes it is moved to path.1, replacing any previous one, and a new file started.
+func (c *Capture) ToFile(path string, maxBytes int64) (*Capture, error) {
+	f, err := openRotatingFile(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	c.file = f
+	c.Unlock()
+	return c, nil
+}
+
+func (c *Capture) sample() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.rand.Float64() < c.sampleRate
+}
+
+// Records a call. req and resp are copied, so may be reused after.
+func (c *Capture) add(start time.Time, req, resp []byte, err error) {
+	call := CapturedCall{
+		Time:     start,
+		Duration: time.Since(start),
+		Request:  append([]byte(nil), req...),
+		Response: append([]byte(nil), resp...),
+	}
+	call.Method, call.Protocol = peekMessage(req)
+	if err != nil {
+		call.Error = err.Error()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.size > 0 {
+		if len(c.calls) < c.size {
+			c.calls = append(c.calls, call)
+		} else {
+			c.calls[c.next] = call
+		}
+		c.next = (c.next + 1) % c.size
+	}
+
+	if c.file != nil {
+		if err := c.file.writeJSON(call); err != nil {
+			logger.Error("failed to write captured call", "err", err)
+		}
+	}
+}
+
+// Calls returns the buffered calls, oldest first.
+func (c *Capture) Calls() []CapturedCall {
+	c.Lock()
+	defer c.Unlock()
+	res := make([]CapturedCall, 0, len(c.calls))
+	if len(c.calls) == c.size {
+		res = append(res, c.calls[c.next:]...)
+		return append(res, c.calls[:c.next]...)
+	}
+	return append(res, c.calls...)
+}
+
+func (c *Capture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/x-ndjson")
+	w.Header().Add("Content-Disposition", `attachment; filename="thrift-capture.json"`)
+	enc := json.NewEncoder(w)
+	for _, call := range c.Calls() {
+		enc.Encode(call)
+	}
+}
+
+// Describes the page for the adminz index.
+func (c *Capture) AdminDescription() string {
+	return "Download recently captured thrift calls, for Replay"
+}
+
+// Reads calls, as written by a Capture's file or page, for Replay.
+func ReadCapturedCalls(r io.Reader) ([]CapturedCall, error) {
+	var res []CapturedCall
+	dec := json.NewDecoder(r)
+	for {
+		var call CapturedCall
+		if err := dec.Decode(&call); err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return res, err
+		}
+		res = append(res, call)
+	}
+}
+
+// Returns the method name and protocol of the thrift message in body, or empty strings if it
+// cannot be read.
+func peekMessage(body []byte) (method, protocol string) {
+	name, _, _, err := readProtocol(body).ReadMessageBegin()
+	if err != nil {
+		return "", ""
+	}
+	if body[0] == thrift.COMPACT_PROTOCOL_ID {
+		return name, "compact"
+	}
+	return name, "binary"
+}
+
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	f        *os.File
+	w        *bufio.Writer
+	written  int64
+}
+
+func openRotatingFile(path string, maxBytes int64) (*rotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingFile{path, maxBytes, f, bufio.NewWriter(f), info.Size()}, nil
+}
+
+func (r *rotatingFile) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var rotateErr error
+	if r.written > 0 && r.written+int64(len(b))+1 > r.maxBytes {
+		rotateErr = r.rotate()
+	}
+	n, err := fmt.Fprintf(r.w, "%s\n", b)
+	r.written += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// Moves the file to path.1 and starts a new one. If it can't be moved, the file is reopened to
+// carry on appending to, and rotating is tried again on the next write.
+func (r *rotatingFile) rotate() error {
+	r.w.Flush()
+	r.f.Close()
+	renameErr := os.Rename(r.path, r.path+".1")
+	flag := os.O_TRUNC
+	if renameErr != nil {
+		flag = os.O_APPEND
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|flag, 0644)
+	if err != nil {
+		return err
+	}
+	r.f, r.w = f, bufio.NewWriter(f)
+	if renameErr != nil {
+		return renameErr
+	}
+	r.written = 0
+	return nil
+}
@@ -0,0 +1,115 @@
+package thriftrpc
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	capture := NewCapture(2, 1.0)
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("ping", "pong"), nil, false), nil).Capture(capture))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	assertCall(t, recv, send, "ping")
+	recv, send = NewClientProts(ts.URL, true)
+	assertCall(t, recv, send, "ping")
+	assertCall(t, recv, send, "pong")
+
+	calls := capture.Calls()
+	assert.Equal(t, 2, len(calls), "should keep only the most recent")
+	assert.Equal(t, "ping", calls[0].Method)
+	assert.Equal(t, "compact", calls[0].Protocol)
+	assert.Equal(t, "pong", calls[1].Method)
+
+	w := httptest.NewRecorder()
+	capture.ServeHTTP(w, httptest.NewRequest("GET", "/capturez", nil))
+	downloaded, err := ReadCapturedCalls(w.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, calls[1].Request, downloaded[1].Request)
+	assert.Equal(t, calls[1].Response, downloaded[1].Response)
+
+	for _, res := range Replay(downloaded, ts.URL, nil) {
+		assert.True(t, res.Matched(), "replay against the same server should match: %v %v", res.Err, res.Diffs)
+	}
+
+	changed := newFakeProcessor("ping", "pong")
+	changed["pong"] = replyFunc{"pong", "changed"}
+	other := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(changed, nil, false), nil))
+	defer other.Close()
+
+	res := Replay(downloaded, other.URL, nil)
+	assert.True(t, res[0].Matched())
+	assert.False(t, res[1].Matched())
+	assert.Equal(t, []string{`0: "reply:pong" != "changed"`}, res[1].Diffs)
+}
+
+func TestCaptureToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "calls.json")
+
+	capture, err := NewCapture(10, 1.0).ToFile(path, 300)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("ping"), nil, false), nil).Capture(capture))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	for i := 0; i < 5; i++ {
+		assertCall(t, recv, send, "ping")
+	}
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	current, err := ReadCapturedCalls(f)
+	assert.Nil(t, err)
+
+	f1, err := os.Open(path + ".1")
+	assert.Nil(t, err, "file should have rotated")
+	defer f1.Close()
+	rotated, err := ReadCapturedCalls(f1)
+	assert.Nil(t, err)
+
+	assert.True(t, len(current) > 0)
+	assert.True(t, len(current)+len(rotated) <= 5)
+	assert.Equal(t, "ping", current[0].Method)
+}
+
+func TestCaptureWithoutBuffer(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		capture := NewCapture(size, 1.0)
+		capture.add(time.Now(), nil, nil, nil)
+		assert.Empty(t, capture.Calls(), "size %d", size)
+	}
+}
+
+func TestCaptureRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "calls.json")
+	// a non-empty directory in the way of path.1 stops the file being moved.
+	assert.Nil(t, os.MkdirAll(filepath.Join(path+".1", "x"), 0755))
+
+	f, err := openRotatingFile(path, 10)
+	assert.Nil(t, err)
+	assert.Nil(t, f.writeJSON("first"))
+	assert.NotNil(t, f.writeJSON("second"), "rotating should fail")
+	assert.Nil(t, os.RemoveAll(path+".1"))
+	assert.Nil(t, f.writeJSON("third"))
+
+	rotated, err := ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.Equal(t, "\"first\"\n\"second\"\n", string(rotated), "writes carry on after a failed rotation")
+	current, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "\"third\"\n", string(current))
+}
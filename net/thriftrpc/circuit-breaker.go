@@ -0,0 +1,184 @@
+package thriftrpc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foursquare/fsgo/report"
+)
+
+// Returned by clients instead of sending a request while the breaker for its destination is open.
+var ErrCircuitOpen = errors.New("thriftrpc: circuit breaker open")
+
+type BreakerState int
+
+const (
+	// Requests flow normally while consecutive failures are counted.
+	BreakerClosed BreakerState = iota
+	// Requests are rejected with ErrCircuitOpen until the cool-down has elapsed.
+	BreakerOpen
+	// A limited number of trial requests are let through to probe the destination.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker tracks the health of each destination a client sends to, failing fast when a
+// destination has been failing rather than continuing to hammer it.
+//
+// The config fields may be changed before the breaker is first used, but not after.
+type CircuitBreaker struct {
+	// Consecutive failures after which the circuit opens. Defaults to 5.
+	FailureThreshold int
+	// How long an open circuit waits before letting trial requests through. Defaults to 10 seconds.
+	CoolDown time.Duration
+	// Number of concurrent trial requests allowed while half-open. Defaults to 1.
+	HalfOpenRequests int
+
+	stats *report.Recorder
+	now   func() time.Time
+
+	sync.Mutex
+	circuits map[string]*circuit
+}
+
+// per-destination state.
+type circuit struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+// Creates a CircuitBreaker with default settings. Transitions are counted in `stats` if non-nil.
+func NewCircuitBreaker(stats *report.Recorder) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: 5,
+		CoolDown:         10 * time.Second,
+		HalfOpenRequests: 1,
+		stats:            stats,
+		now:              time.Now,
+		circuits:         make(map[string]*circuit),
+	}
+}
+
+// Internal helper to find or create the circuit for dest. MUST be called while holding b.Lock.
+func (b *CircuitBreaker) get(dest string) *circuit {
+	c, ok := b.circuits[dest]
+	if !ok {
+		c = &circuit{}
+		b.circuits[dest] = c
+	}
+	return c
+}
+
+// Internal helper to move dest's circuit to a new state. MUST be called while holding b.Lock.
+func (b *CircuitBreaker) transition(dest string, c *circuit, to BreakerState) {
+	logger.Warn("circuit state changed", "dest", dest, "from", c.state, "to", to)
+	if b.stats != nil {
+		b.stats.Inc("rpc.breaker." + metricSafe(dest) + "." + strings.Replace(to.String(), "-", "_", -1))
+	}
+	c.state = to
+	c.trials = 0
+	if to == BreakerOpen {
+		c.openedAt = b.now()
+	}
+	if to == BreakerClosed {
+		c.failures = 0
+	}
+}
+
+// Allow returns nil if a request to dest may be sent, or ErrCircuitOpen if not. Every allowed
+// request must be followed by a call to either Success or Failure.
+func (b *CircuitBreaker) Allow(dest string) error {
+	b.Lock()
+	defer b.Unlock()
+	c := b.get(dest)
+
+	if c.state == BreakerOpen {
+		if b.now().Sub(c.openedAt) < b.CoolDown {
+			b.reject(dest)
+			return ErrCircuitOpen
+		}
+		b.transition(dest, c, BreakerHalfOpen)
+	}
+
+	if c.state == BreakerHalfOpen {
+		if c.trials >= b.HalfOpenRequests {
+			b.reject(dest)
+			return ErrCircuitOpen
+		}
+		c.trials++
+	}
+	return nil
+}
+
+// Counts a request turned away, whether open or waiting on half-open trials.
+func (b *CircuitBreaker) reject(dest string) {
+	if b.stats != nil {
+		b.stats.Inc("rpc.breaker." + metricSafe(dest) + ".rejected")
+	}
+}
+
+// Success records a successful request to dest.
+func (b *CircuitBreaker) Success(dest string) {
+	b.Lock()
+	defer b.Unlock()
+	c := b.get(dest)
+
+	c.failures = 0
+	if c.state == BreakerHalfOpen {
+		b.transition(dest, c, BreakerClosed)
+	}
+}
+
+// Failure records a failed request to dest.
+func (b *CircuitBreaker) Failure(dest string) {
+	b.Lock()
+	defer b.Unlock()
+	c := b.get(dest)
+
+	c.failures++
+	switch c.state {
+	case BreakerHalfOpen:
+		b.transition(dest, c, BreakerOpen)
+	case BreakerClosed:
+		if c.failures >= b.FailureThreshold {
+			b.transition(dest, c, BreakerOpen)
+		}
+	}
+}
+
+// Internal helper for requests that were allowed but then cancelled by the caller, which count
+// as neither success nor failure.
+func (b *CircuitBreaker) abandon(dest string) {
+	b.Lock()
+	defer b.Unlock()
+	if c := b.get(dest); c.state == BreakerHalfOpen && c.trials > 0 {
+		c.trials--
+	}
+}
+
+// State returns the current state of the circuit for dest.
+func (b *CircuitBreaker) State(dest string) BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	return b.get(dest).state
+}
+
+// Makes a host or method name usable as a single graphite path component.
+func metricSafe(s string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "/", "_").Replace(s)
+}
@@ -0,0 +1,104 @@
+package thriftrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/foursquare/fsgo/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBreaker() (*CircuitBreaker, *time.Time) {
+	now := time.Now()
+	b := NewCircuitBreaker(nil)
+	b.FailureThreshold = 3
+	b.CoolDown = time.Second
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	b, now := newTestBreaker()
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, b.Allow("a"))
+		b.Failure("a")
+	}
+	assert.Equal(t, BreakerClosed, b.State("a"), "should tolerate failures below threshold")
+
+	assert.Nil(t, b.Allow("a"))
+	b.Failure("a")
+	assert.Equal(t, BreakerOpen, b.State("a"))
+	assert.Equal(t, ErrCircuitOpen, b.Allow("a"))
+	assert.Equal(t, BreakerClosed, b.State("b"), "destinations should be tracked separately")
+
+	*now = now.Add(2 * time.Second)
+	assert.Nil(t, b.Allow("a"), "should allow a trial after cool-down")
+	assert.Equal(t, BreakerHalfOpen, b.State("a"))
+	assert.Equal(t, ErrCircuitOpen, b.Allow("a"), "should allow only one trial at a time")
+
+	b.Failure("a")
+	assert.Equal(t, BreakerOpen, b.State("a"), "failed trial should re-open")
+
+	*now = now.Add(2 * time.Second)
+	assert.Nil(t, b.Allow("a"))
+	b.Success("a")
+	assert.Equal(t, BreakerClosed, b.State("a"), "successful trial should close")
+}
+
+func TestBreakerRejectedCount(t *testing.T) {
+	b, now := newTestBreaker()
+	b.stats = report.NewRecorder()
+	for i := 0; i < 3; i++ {
+		b.Allow("a")
+		b.Failure("a")
+	}
+	assert.Equal(t, ErrCircuitOpen, b.Allow("a"))
+	*now = now.Add(2 * time.Second)
+	assert.Nil(t, b.Allow("a"))
+	assert.Equal(t, ErrCircuitOpen, b.Allow("a"), "half-open, with a trial in flight")
+	assert.Equal(t, int64(2), b.stats.GetMeter("rpc.breaker.a.rejected").Count())
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b, _ := newTestBreaker()
+	for i := 0; i < 10; i++ {
+		b.Allow("a")
+		b.Failure("a")
+		b.Allow("a")
+		b.Success("a")
+	}
+	assert.Equal(t, BreakerClosed, b.State("a"))
+}
+
+func TestClientBreaker(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Error(w, "down", 500)
+	}))
+	defer ts.Close()
+
+	b, _ := newTestBreaker()
+	recv, send := NewClientProtsWithOptions(ts.URL, false, &ClientOptions{Breaker: b})
+
+	for i := 0; i < 5; i++ {
+		_, err := call(recv, send, "ping")
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, 3, hits, "should stop sending once open")
+	assert.Equal(t, BreakerOpen, b.State(hostOf(ts.URL)))
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("ping"), nil, false), nil))
+	defer ts.Close()
+
+	for _, compact := range []bool{false, true} {
+		recv, send := NewClientProtsWithOptions(ts.URL, compact, &ClientOptions{Breaker: NewCircuitBreaker(nil)})
+		assertCall(t, recv, send, "ping")
+		assertCall(t, recv, send, "ping")
+	}
+}
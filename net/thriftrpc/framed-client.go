@@ -0,0 +1,207 @@
+package thriftrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/foursquare/fsgo/report"
+)
+
+// framedPool keeps idle connections to framed thrift servers for reuse, keyed by host:port.
+type framedPool struct {
+	maxIdlePerHost int
+	// idle connections are closed after this long; zero means never.
+	idleTimeout time.Duration
+	dial        dialFunc
+	stats       *report.Recorder
+
+	sync.Mutex
+	idle      map[string][]*framedConn
+	idleCount int64
+}
+
+type framedConn struct {
+	net.Conn
+	r *bufio.Reader
+	// when the conn was returned to the pool.
+	idleSince time.Time
+}
+
+func newFramedPool(maxIdlePerHost int, idleTimeout time.Duration, dial dialFunc, stats *report.Recorder) *framedPool {
+	return &framedPool{
+		maxIdlePerHost: maxIdlePerHost,
+		idleTimeout:    idleTimeout,
+		dial:           dial,
+		stats:          stats,
+		idle:           make(map[string][]*framedConn),
+	}
+}
+
+// Internal helper to close connections idle for longer than idleTimeout. MUST be called while
+// holding p.Lock.
+func (p *framedPool) closeExpired(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	for host, idle := range p.idle {
+		// conns are appended as they become idle, so the oldest are first.
+		n := 0
+		for n < len(idle) && now.Sub(idle[n].idleSince) >= p.idleTimeout {
+			idle[n].Close()
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		if n == len(idle) {
+			delete(p.idle, host)
+		} else {
+			p.idle[host] = append(idle[:0], idle[n:]...)
+		}
+		p.addIdle(int64(-n))
+	}
+}
+
+// Internal helper to update idle count and metric. MUST be called while holding p.Lock.
+func (p *framedPool) addIdle(delta int64) {
+	p.idleCount += delta
+	if p.stats != nil {
+		p.stats.GetGuage("rpc.client.pool.framed_idle").Update(p.idleCount)
+	}
+}
+
+// Returns an idle connection to host if there is one the server hasn't closed, otherwise dials a
+// new one.
+func (p *framedPool) get(ctx context.Context, host string) (conn *framedConn, reused bool, err error) {
+	for {
+		conn = nil
+		p.Lock()
+		p.closeExpired(time.Now())
+		if idle := p.idle[host]; len(idle) > 0 {
+			conn = idle[len(idle)-1]
+			p.idle[host] = idle[:len(idle)-1]
+			p.addIdle(-1)
+		}
+		p.Unlock()
+		if conn == nil {
+			break
+		}
+		if !conn.usable() {
+			conn.Close()
+			if p.stats != nil {
+				p.stats.Inc("rpc.client.pool.framed_stale")
+			}
+			continue
+		}
+		if p.stats != nil {
+			p.stats.Inc("rpc.client.pool.framed_reused")
+		}
+		return conn, true, nil
+	}
+
+	c, err := p.dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, false, err
+	}
+	if p.stats != nil {
+		p.stats.Inc("rpc.client.pool.framed_new")
+	}
+	return &framedConn{Conn: c, r: bufio.NewReader(c)}, false, nil
+}
+
+// Checks, without blocking, that the server hasn't closed an idle conn and hasn't sent anything
+// unexpected, and clears any deadline left from its last use.
+func (c *framedConn) usable() bool {
+	if c.r.Buffered() > 0 || peerClosed(c.Conn) {
+		return false
+	}
+	return c.SetDeadline(time.Time{}) == nil
+}
+
+// Returns conn to the pool, or closes it if the pool for host is full. Connections left idle too
+// long, to any host, are closed whenever one is taken from or returned to the pool.
+func (p *framedPool) put(host string, conn *framedConn) {
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now()
+	p.closeExpired(now)
+	if len(p.idle[host]) >= p.maxIdlePerHost {
+		conn.Close()
+		return
+	}
+	conn.idleSince = now
+	p.idle[host] = append(p.idle[host], conn)
+	p.addIdle(1)
+}
+
+// Sends body to host as a single frame and returns the response frame, or nil for oneway calls.
+func (p *framedPool) roundTrip(ctx context.Context, host string, body []byte, oneway bool) ([]byte, error) {
+	resp, retry, err := p.tryRoundTrip(ctx, host, body, oneway)
+	if retry {
+		resp, _, err = p.tryRoundTrip(ctx, host, body, oneway)
+	}
+	return resp, err
+}
+
+// retry is true if the attempt failed writing the request to a pooled connection, so the server
+// can't have processed it, and it can safely be sent again on a fresh connection. Failures reading
+// the response aren't retried, as the server may have processed the request before closing.
+func (p *framedPool) tryRoundTrip(ctx context.Context, host string, body []byte, oneway bool) (resp []byte, retry bool, err error) {
+	conn, reused, err := p.get(ctx, host)
+	if err != nil {
+		return nil, false, err
+	}
+	stop := watchContext(ctx, conn)
+
+	if err := writeFrame(conn, body); err != nil {
+		stop()
+		conn.Close()
+		return nil, reused && ctx.Err() == nil, err
+	}
+
+	if !oneway {
+		var buf bytes.Buffer
+		if err := readFrame(conn.r, &buf); err != nil {
+			stop()
+			conn.Close()
+			return nil, false, err
+		}
+		resp = buf.Bytes()
+	}
+
+	if !stop() {
+		// cancelled, so the conn's deadline has been set to unblock it.
+		conn.Close()
+		return nil, false, ctx.Err()
+	}
+	p.put(host, conn)
+	return resp, false, nil
+}
+
+// Unblocks any pending read or write on conn if ctx is done. The returned func stops watching,
+// waiting until the watcher has finished, so it can't touch conn once returned to the pool, and
+// reports whether conn is still usable, which it isn't if the watcher set its deadline.
+func watchContext(ctx context.Context, conn net.Conn) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return true }
+	}
+	done := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			cancelled <- true
+		case <-done:
+			cancelled <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return !<-cancelled
+	}
+}
@@ -0,0 +1,28 @@
+package thriftrpc
+
+import (
+	"net"
+	"syscall"
+)
+
+// Reports whether the server has closed conn, or sent something unexpected, by peeking at it
+// without blocking.
+func peerClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	closed := false
+	var buf [1]byte
+	err = raw.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// n is 0, with no error, once the server has closed its end.
+		closed = err != syscall.EAGAIN && err != syscall.EWOULDBLOCK || n > 0
+		return true
+	})
+	return closed || err != nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package thriftrpc
+
+import "net"
+
+// Closed conns can't be detected without blocking on this platform, so are only found when a
+// request on them fails.
+func peerClosed(conn net.Conn) bool {
+	return false
+}
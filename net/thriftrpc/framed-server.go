@@ -0,0 +1,142 @@
+package thriftrpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/foursquare/fsgo/report"
+)
+
+// Frames larger than this are rejected rather than read into memory.
+const maxFrameSize = thrift.DEFAULT_MAX_LENGTH
+
+// FramedServer serves thrift RPC as framed thrift over plain TCP, for peers that don't speak
+// HTTP. As with ThriftOverHTTPHandler, binary or compact protocol is detected per-request, and
+// generated processors should be wrapped with AddLogging (or a ServiceMux) for logging and metrics.
+type FramedServer struct {
+	thrift.TProcessor
+	stats   *report.Recorder
+	buffers bufferPool
+
+	sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+func NewFramedServer(p thrift.TProcessor, stats *report.Recorder) *FramedServer {
+	return &FramedServer{TProcessor: p, stats: stats}
+}
+
+// Listens on the TCP address addr and serves connections until Close is called.
+func (s *FramedServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serves connections accepted from l until Close is called, at which point it returns nil.
+func (s *FramedServer) Serve(l net.Listener) error {
+	s.Lock()
+	s.listener = l
+	s.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Stops accepting new connections. Open connections are served until their clients close them.
+func (s *FramedServer) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *FramedServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		// wait for the next request before starting the clock.
+		if _, err := r.Peek(1); err != nil {
+			return
+		}
+		if err := s.serveFrame(r, conn, conn.RemoteAddr().String()); err != nil {
+			logger.Warn("closing connection after error", "peer", conn.RemoteAddr(), "err", err)
+			return
+		}
+	}
+}
+
+func (s *FramedServer) serveFrame(r io.Reader, w io.Writer, peer string) error {
+	start := time.Now()
+	if s.stats != nil {
+		defer s.stats.TimeSince("servetcp", start)
+	}
+
+	inbuf := s.buffers.get()
+	defer s.buffers.put(inbuf)
+	outbuf := s.buffers.get()
+	defer s.buffers.put(outbuf)
+
+	if err := readFrame(r, inbuf); err != nil {
+		return err
+	}
+
+	iprot, oprot := serverProtocols(isCompact(inbuf.Bytes()), inbuf, outbuf)
+	iprot = withCallInfo(iprot, &callInfo{peer: peer, requestSize: inbuf.Len()})
+	if ok, err := s.Process(iprot, oprot); !ok {
+		return err
+	}
+
+	// oneway calls have no response.
+	if outbuf.Len() == 0 {
+		return nil
+	}
+	return writeFrame(w, outbuf.Bytes())
+}
+
+// Reads a single length-prefixed frame from r into buf.
+func readFrame(r io.Reader, buf io.Writer) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return fmt.Errorf("thriftrpc: frame size %d exceeds max of %d", size, maxFrameSize)
+	}
+	_, err := io.CopyN(buf, r, int64(size))
+	return err
+}
+
+// Writes body to w as a single length-prefixed frame.
+func writeFrame(w io.Writer, body []byte) error {
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,141 @@
+package thriftrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/foursquare/fsgo/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramedRoundTrip(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	stats := report.NewRecorder()
+	s := NewFramedServer(AddLogging(newFakeProcessor("ping"), stats, false), stats)
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+
+	url := "tcp://" + l.Addr().String()
+	for _, compact := range []bool{false, true} {
+		recv, send := NewClientProts(url, compact)
+		assertCall(t, recv, send, "ping")
+		assertCall(t, recv, send, "ping")
+
+		_, err := call(recv, send, "nope")
+		assert.NotNil(t, err, "unknown methods should get an exception")
+		assertCall(t, recv, send, "ping")
+	}
+	assert.Equal(t, int64(6), stats.GetTimer("rpc.timing.ping").Count())
+	assert.Equal(t, int64(8), stats.GetTimer("servetcp").Count())
+
+	assert.Nil(t, s.Close())
+	assert.Nil(t, <-served)
+}
+
+// remembers accepted connections so tests can close them server-side.
+type trackingListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func (l trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.conns <- c
+	}
+	return c, err
+}
+
+func TestFramedPoolRetriesClosedConns(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	l := trackingListener{inner, make(chan net.Conn, 10)}
+	s := NewFramedServer(AddLogging(newFakeProcessor("ping"), nil, false), nil)
+	go s.Serve(l)
+	defer s.Close()
+
+	stats := report.NewRecorder()
+	opts := DefaultTransportOptions()
+	opts.MaxIdleConnsPerHost = 1
+	transport, err := NewTransport(opts, stats)
+	assert.Nil(t, err)
+	recv, send := NewClientProtsWithOptions("tcp://"+l.Addr().String(), false, &ClientOptions{Transport: transport})
+	assertCall(t, recv, send, "ping")
+
+	// close the pooled connection out from under the client, as a server restart would.
+	(<-l.conns).Close()
+	time.Sleep(10 * time.Millisecond)
+	assertCall(t, recv, send, "ping")
+	assert.Equal(t, 1, len(l.conns), "should have used a new connection")
+	assert.Equal(t, int64(2), stats.GetMeter("rpc.client.pool.framed_new").Count())
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.client.pool.framed_stale").Count())
+	assert.Equal(t, int64(1), stats.GetGuage("rpc.client.pool.framed_idle").Value())
+
+	assertCall(t, recv, send, "ping")
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.client.pool.framed_reused").Count())
+}
+
+func TestFramedPoolCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := NewFramedServer(AddLogging(newFakeProcessor("ping"), nil, false), nil)
+	go s.Serve(l)
+	defer s.Close()
+
+	transport, err := NewTransport(DefaultTransportOptions(), nil)
+	assert.Nil(t, err)
+	recv, send := NewClientProtsWithOptions("tcp://"+l.Addr().String(), false, &ClientOptions{Transport: transport})
+
+	// requests whose context is cancelled once they've completed, as sendHedged's are, must leave
+	// usable conns in the pool.
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		resp, err := transport.framed.roundTrip(ctx, l.Addr().String(), pingRequest(), false)
+		cancel()
+		assert.Nil(t, err)
+		assert.NotEmpty(t, resp)
+		assertCall(t, recv, send, "ping")
+	}
+}
+
+// A binary encoded call to ping, as a framed client sends.
+func pingRequest() []byte {
+	buf := thrift.NewTMemoryBuffer()
+	p := thrift.NewTBinaryProtocolTransport(buf)
+	p.WriteMessageBegin("ping", thrift.CALL, 1)
+	p.WriteStructBegin("args")
+	p.WriteFieldStop()
+	p.WriteStructEnd()
+	p.WriteMessageEnd()
+	return buf.Bytes()
+}
+
+func TestFramedPoolIdleTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := NewFramedServer(AddLogging(newFakeProcessor("ping"), nil, false), nil)
+	go s.Serve(l)
+	defer s.Close()
+
+	stats := report.NewRecorder()
+	opts := DefaultTransportOptions()
+	opts.IdleConnTimeout = 20 * time.Millisecond
+	transport, err := NewTransport(opts, stats)
+	assert.Nil(t, err)
+	recv, send := NewClientProtsWithOptions("tcp://"+l.Addr().String(), false, &ClientOptions{Transport: transport})
+
+	assertCall(t, recv, send, "ping")
+	assertCall(t, recv, send, "ping")
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.client.pool.framed_new").Count())
+
+	time.Sleep(40 * time.Millisecond)
+	assertCall(t, recv, send, "ping")
+	assert.Equal(t, int64(2), stats.GetMeter("rpc.client.pool.framed_new").Count(), "idle conn should have been closed")
+	assert.Equal(t, int64(1), stats.GetGuage("rpc.client.pool.open").Value())
+	assert.Equal(t, int64(1), stats.GetGuage("rpc.client.pool.framed_idle").Value())
+}
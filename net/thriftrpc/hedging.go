@@ -0,0 +1,148 @@
+package thriftrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// How often a method's hedge delay is recomputed from its latencies.
+const hedgeDelayInterval = time.Second
+
+// HedgePolicy configures hedged requests: if a call to an opted-in method has not completed
+// within a delay derived from that method's observed latency, a second copy of the request is
+// sent to another destination, the first successful response is used and the other is cancelled.
+// Calls are never hedged to the same host as the first request, so clients with a static url
+// don't hedge at all.
+//
+// Only idempotent, read-only methods should be hedged, since both copies may be processed.
+type HedgePolicy struct {
+	// Methods that may be hedged, by thrift method name (without any multiplexed service prefix).
+	Methods map[string]bool
+	// Percentile of the method's recent latency after which to hedge. Defaults to 0.95.
+	Percentile float64
+	// Delay used until MinSamples calls have been timed, and the floor for computed delays.
+	MinDelay time.Duration
+	// Number of timed calls needed before the percentile is trusted. Defaults to 100.
+	MinSamples int64
+
+	sync.Mutex
+	// by method, tracked separately from rpc.client.timing, which graphite export clears.
+	latencies map[string]*hedgeLatency
+}
+
+// Recent latencies of a hedged method, and the delay last computed from them.
+type hedgeLatency struct {
+	sample   metrics.Sample
+	delay    time.Duration
+	computed time.Time
+}
+
+// Creates a HedgePolicy with default settings for the given methods.
+func NewHedgePolicy(methods ...string) *HedgePolicy {
+	h := &HedgePolicy{
+		Methods:    make(map[string]bool),
+		Percentile: 0.95,
+		MinDelay:   10 * time.Millisecond,
+		MinSamples: 100,
+	}
+	for _, m := range methods {
+		h.Methods[m] = true
+	}
+	return h
+}
+
+type sendResult struct {
+	resp []byte
+	err  error
+}
+
+// Records how long a call to method took.
+func (h *HedgePolicy) observe(method string, took time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	if h.latencies == nil {
+		h.latencies = make(map[string]*hedgeLatency)
+	}
+	l, ok := h.latencies[method]
+	if !ok {
+		l = &hedgeLatency{sample: metrics.NewExpDecaySample(1028, 0.015)}
+		h.latencies[method] = l
+	}
+	l.sample.Update(int64(took))
+}
+
+// How long to wait for the first request to method before hedging. The percentile is only
+// recomputed every hedgeDelayInterval, to keep it off most requests' paths.
+func (h *HedgePolicy) delay(method string) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	l, ok := h.latencies[method]
+	if !ok || l.sample.Count() < h.MinSamples {
+		return h.MinDelay
+	}
+	if now := time.Now(); now.Sub(l.computed) >= hedgeDelayInterval {
+		l.delay = time.Duration(l.sample.Percentile(h.Percentile))
+		l.computed = now
+	}
+	if l.delay < h.MinDelay {
+		return h.MinDelay
+	}
+	return l.delay
+}
+
+// Picks a url for the hedged request on a different host than the first request, if there is one.
+func (t *sendProt) hedgeURL(first string) (string, bool) {
+	host := hostOf(first)
+	for i := 0; i < 3; i++ {
+		if u := t.url(); hostOf(u) != host {
+			return u, true
+		}
+	}
+	return "", false
+}
+
+func (t *sendProt) sendHedged(body []byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// sendbuf is reused as soon as Flush returns, while the losing request may still be sending.
+	body = append([]byte(nil), body...)
+
+	results := make(chan sendResult, 2)
+	first := t.url()
+	go func() {
+		resp, err := t.send(ctx, first, body)
+		results <- sendResult{resp, err}
+	}()
+	pending := 1
+
+	delay := time.NewTimer(t.opts.Hedge.delay(t.service + "." + t.method))
+	defer delay.Stop()
+
+	for {
+		select {
+		case <-delay.C:
+			second, ok := t.hedgeURL(first)
+			if !ok {
+				// hedging to the same, already slow, host would only add to its load.
+				continue
+			}
+			if t.opts.Stats != nil {
+				t.opts.Stats.Inc(t.metric("hedged"))
+			}
+			go func() {
+				resp, err := t.send(ctx, second, body)
+				results <- sendResult{resp, err}
+			}()
+			pending++
+		case res := <-results:
+			pending--
+			if res.err == nil || pending == 0 {
+				return res.resp, res.err
+			}
+		}
+	}
+}
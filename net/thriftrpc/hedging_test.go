@@ -0,0 +1,90 @@
+package thriftrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/foursquare/fsgo/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgedCall(t *testing.T) {
+	handler := NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("get", "put"), nil, false), nil)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(handler)
+	defer fast.Close()
+
+	// the first url picked for each call is the slow server, any after that are fast.
+	var calls int32
+	url := func() string {
+		if atomic.AddInt32(&calls, 1)%2 == 1 {
+			return slow.URL
+		}
+		return fast.URL
+	}
+
+	stats := report.NewRecorder()
+	hedge := NewHedgePolicy("get")
+	hedge.MinDelay = 20 * time.Millisecond
+	recv, send := NewDynamicClientProtsWithOptions(url, false, &ClientOptions{Hedge: hedge, Stats: stats})
+
+	start := time.Now()
+	assertCall(t, recv, send, "get")
+	assert.True(t, time.Since(start) < 400*time.Millisecond, "hedged request should win")
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.client.hedged.get").Count())
+
+	atomic.StoreInt32(&calls, 0)
+	start = time.Now()
+	assertCall(t, recv, send, "put")
+	assert.True(t, time.Since(start) >= 500*time.Millisecond, "methods not opted-in should never be hedged")
+	assert.Equal(t, int64(0), stats.GetMeter("rpc.client.hedged.put").Count())
+}
+
+func TestHedgeSameHost(t *testing.T) {
+	var hits int32
+	handler := NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("get"), nil, false), nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(50 * time.Millisecond)
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	stats := report.NewRecorder()
+	hedge := NewHedgePolicy("get")
+	hedge.MinDelay = time.Millisecond
+	recv, send := NewClientProtsWithOptions(ts.URL, false, &ClientOptions{Hedge: hedge, Stats: stats})
+
+	assertCall(t, recv, send, "get")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits), "should not hedge to the same host")
+	assert.Equal(t, int64(0), stats.GetMeter("rpc.client.hedged.get").Count())
+}
+
+func TestHedgeDelay(t *testing.T) {
+	hedge := NewHedgePolicy("get")
+	hedge.MinSamples = 10
+	assert.Equal(t, hedge.MinDelay, hedge.delay(".get"), "too few samples")
+
+	for i := 1; i <= 100; i++ {
+		hedge.observe(".get", time.Duration(i)*time.Millisecond)
+	}
+	d := hedge.delay(".get")
+	assert.InDelta(t, float64(95*time.Millisecond), float64(d), float64(2*time.Millisecond))
+	assert.Equal(t, hedge.MinDelay, hedge.delay("svc.get"), "tracked per service")
+
+	// cached until recomputed.
+	for i := 0; i < 1000; i++ {
+		hedge.observe(".get", time.Second)
+	}
+	assert.Equal(t, d, hedge.delay(".get"))
+}
@@ -0,0 +1,141 @@
+package thriftrpc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+const (
+	// Requests larger than this are rejected unless a handler sets its own MaxRequestSize.
+	DefaultMaxRequestSize = maxFrameSize
+	// Responses are written in chunks of about this size rather than buffered whole.
+	DefaultResponseChunkSize = 64 * 1024
+	// Buffers that have grown beyond this are dropped rather than returned to a pool, so one huge
+	// request doesn't pin its memory forever.
+	maxPooledBufferSize = 1 << 20
+)
+
+var (
+	errReadOnlyTransport  = errors.New("thriftrpc: request transport cannot be written")
+	errWriteOnlyTransport = errors.New("thriftrpc: response transport cannot be read")
+)
+
+// A pool of memory buffers that doesn't retain very large ones.
+type bufferPool struct {
+	sync.Pool
+}
+
+func (p *bufferPool) get() *thrift.TMemoryBuffer {
+	res := p.Get()
+	if res == nil {
+		return thrift.NewTMemoryBuffer()
+	}
+	out := res.(*thrift.TMemoryBuffer)
+	out.Reset()
+	return out
+}
+
+func (p *bufferPool) put(buf *thrift.TMemoryBuffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		p.Put(buf)
+	}
+}
+
+// A read-only transport decoding a request directly from an http request body, without first
+// buffering it. Like a memory buffer, it reports how many bytes remain, so the protocol rejects
+// lengths that could not possibly fit rather than allocating for them.
+type requestBody struct {
+	r         *bufio.Reader
+	remaining int64
+	// set if reading failed because the body exceeded the handler's MaxRequestSize.
+	tooLarge bool
+}
+
+func newRequestBody(r *bufio.Reader, contentLength, max int64) *requestBody {
+	remaining := max
+	if contentLength >= 0 && contentLength < max {
+		remaining = contentLength
+	}
+	return &requestBody{r: r, remaining: remaining}
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if isTooLarge(err) {
+		b.tooLarge = true
+	}
+	return n, err
+}
+
+// Whether the body was longer than allowed. If decoding stopped early, eg at a string length that
+// could not fit, it reads the rest of the body to find out.
+func (b *requestBody) exceeded() bool {
+	if !b.tooLarge {
+		io.Copy(ioutil.Discard, b)
+	}
+	return b.tooLarge
+}
+
+func (b *requestBody) RemainingBytes() uint64 {
+	if b.remaining < 0 {
+		return 0
+	}
+	return uint64(b.remaining)
+}
+
+func (b *requestBody) Write(p []byte) (int, error) { return 0, errReadOnlyTransport }
+func (b *requestBody) Flush() error                { return nil }
+func (b *requestBody) Open() error                 { return nil }
+func (b *requestBody) IsOpen() bool                { return true }
+func (b *requestBody) Close() error                { return nil }
+
+// A write-only transport that buffers a response and, once the buffer passes chunkSize, starts
+// streaming it to the client, which net/http sends with chunked encoding. A chunkSize of 0 keeps
+// the whole response buffered.
+type responseWriter struct {
+	w         http.ResponseWriter
+	buf       *thrift.TMemoryBuffer
+	chunkSize int
+	// bytes already sent to the client.
+	sent int
+}
+
+func (r *responseWriter) Write(p []byte) (int, error) {
+	n, err := r.buf.Write(p)
+	if err == nil && r.chunkSize > 0 && r.buf.Len() >= r.chunkSize {
+		err = r.send()
+	}
+	return n, err
+}
+
+// Sends whatever is buffered to the client.
+func (r *responseWriter) send() error {
+	n, err := r.buf.WriteTo(r.w)
+	r.sent += int(n)
+	return err
+}
+
+// Response size so far, for access logs.
+func (r *responseWriter) Len() int {
+	return r.sent + r.buf.Len()
+}
+
+// Whether any of the response has been sent, after which it can no longer be replaced by an error.
+func (r *responseWriter) started() bool {
+	return r.sent > 0
+}
+
+// Flushing is left to the handler, so that a failed call can still get an error response.
+func (r *responseWriter) Flush() error               { return nil }
+func (r *responseWriter) Read(p []byte) (int, error) { return 0, errWriteOnlyTransport }
+func (r *responseWriter) RemainingBytes() uint64     { return 0 }
+func (r *responseWriter) Open() error                { return nil }
+func (r *responseWriter) IsOpen() bool               { return true }
+func (r *responseWriter) Close() error               { return nil }
@@ -0,0 +1,80 @@
+package thriftrpc
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkedResponse(t *testing.T) {
+	big := strings.Repeat("x", 100*1024)
+	p := newFakeProcessor("small")
+	p["big"] = replyFunc{"big", big}
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(p, nil, false), nil).ResponseChunkSize(1024))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	res, err := call(recv, send, "big")
+	assert.Nil(t, err)
+	assert.Equal(t, big, res)
+	assertCall(t, recv, send, "small")
+
+	resp, err := http.Post(ts.URL, "application/x-thrift", bytes.NewReader(callBytes("big")))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int64(-1), resp.ContentLength, "large responses should be streamed")
+
+	resp, err = http.Post(ts.URL, "application/x-thrift", bytes.NewReader(callBytes("small")))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.True(t, resp.ContentLength > 0, "small responses should be sent whole")
+}
+
+func TestMaxRequestSize(t *testing.T) {
+	long := strings.Repeat("m", 200)
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("ping", long), nil, false), nil).MaxRequestSize(100))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	assertCall(t, recv, send, "ping")
+	_, err := call(recv, send, long)
+	assert.NotNil(t, err)
+
+	// without a content length, the limit is only hit while decoding.
+	for _, name := range []string{"ping", long} {
+		resp, err := http.Post(ts.URL, "application/x-thrift", struct{ io.Reader }{bytes.NewReader(callBytes(name))})
+		assert.Nil(t, err)
+		resp.Body.Close()
+		if name == long {
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		} else {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+}
+
+func TestBufferPoolDropsLargeBuffers(t *testing.T) {
+	var pool bufferPool
+	buf := pool.get()
+	buf.Write(make([]byte, 2*maxPooledBufferSize))
+	pool.put(buf)
+	assert.True(t, pool.get().Cap() < maxPooledBufferSize)
+}
+
+// Encodes a call with no arguments.
+func callBytes(name string) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	p := thrift.NewTBinaryProtocol(buf, true, true)
+	p.WriteMessageBegin(name, thrift.CALL, 1)
+	p.WriteStructBegin("args")
+	p.WriteFieldStop()
+	p.WriteStructEnd()
+	p.WriteMessageEnd()
+	return buf.Bytes()
+}
@@ -1,37 +1,161 @@
 package thriftrpc
 
 import (
-	"io"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/foursquare/fsgo/report"
 )
 
+// ClientOptions configures optional client behavior. A nil *ClientOptions uses the defaults.
+type ClientOptions struct {
+	// If set, requests are guarded by this breaker, tracked separately for each destination host.
+	Breaker *CircuitBreaker
+	// If set, opted-in methods send a second request when the first is slow. See HedgePolicy.
+	Hedge *HedgePolicy
+	// Connection pools and settings to use. Defaults to the shared DefaultTransport.
+	Transport *Transport
+	// If set, client-side call timings and errors are recorded as rpc.client.* (or
+	// rpc.client.<service>.* for multiplexed clients).
+	Stats *report.Recorder
+	// If set, adds credentials to each http request, eg HMACCredentials or a BearerToken. Client
+	// certificates for mTLS are configured on the Transport instead.
+	Credentials CredentialProvider
+}
+
 type sendProt struct {
-	transport *http.Client
+	transport *Transport
 	url       func() string
+	opts      ClientOptions
 	sendbuf   *thrift.TMemoryBuffer
 	recvbuf   *thrift.TMemoryBuffer
 
+	// service and method currently being sent, as seen by WriteMessageBegin. service is only set
+	// when the name was prefixed by a TMultiplexedProtocol.
+	service string
+	method  string
+	// oneway calls get no response over framed transports, so we must not wait for one.
+	oneway bool
+
 	thrift.TProtocol
 }
 
+func (t *sendProt) WriteMessageBegin(name string, typeId thrift.TMessageType, seqid int32) error {
+	t.service, t.method = splitServiceName(name)
+	t.oneway = typeId == thrift.ONEWAY
+	return t.TProtocol.WriteMessageBegin(name, typeId, seqid)
+}
+
 func (t *sendProt) Flush() error {
-	req, err := http.NewRequest("POST", t.url(), t.sendbuf)
-	req.Header.Set("Content-Length", string(t.sendbuf.Len()))
-	req.Header.Set("Content-Type", "application/x-thrift")
+	defer t.sendbuf.Reset()
+
+	start := time.Now()
+	var resp []byte
+	var err error
+	if t.opts.Hedge != nil && t.opts.Hedge.Methods[t.method] {
+		resp, err = t.sendHedged(t.sendbuf.Bytes())
+		if err == nil {
+			t.opts.Hedge.observe(t.service+"."+t.method, time.Since(start))
+		}
+	} else {
+		resp, err = t.send(context.Background(), t.url(), t.sendbuf.Bytes())
+	}
+
+	if t.opts.Stats != nil {
+		if err != nil {
+			t.opts.Stats.Inc(t.metric("error"))
+		}
+		t.opts.Stats.TimeSince(t.metric("timing"), start)
+	}
 
-	resp, err := t.transport.Do(req)
 	if err != nil {
 		return err
 	}
+	t.recvbuf.Write(resp)
+	return nil
+}
+
+// Name of the client-side metric of the given kind for the current method.
+func (t *sendProt) metric(kind string) string {
+	if t.service != "" {
+		return "rpc.client." + t.service + "." + kind + "." + t.method
+	}
+	return "rpc.client." + kind + "." + t.method
+}
+
+// Sends body to url, going through the circuit breaker if there is one.
+func (t *sendProt) send(ctx context.Context, url string, body []byte) ([]byte, error) {
+	b := t.opts.Breaker
+	if b == nil {
+		return t.roundTrip(ctx, url, body)
+	}
+
+	dest := hostOf(url)
+	if err := b.Allow(dest); err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(ctx, url, body)
+	switch {
+	case err == nil:
+		b.Success(dest)
+	case ctx.Err() != nil:
+		// we gave up on this request ourselves, which says nothing about dest.
+		b.abandon(dest)
+	default:
+		b.Failure(dest)
+	}
+	return resp, err
+}
+
+// Sends body to url over the transport selected by its scheme: framed thrift over TCP for
+// tcp://host:port urls, or thrift-over-http otherwise.
+func (t *sendProt) roundTrip(ctx context.Context, url string, body []byte) ([]byte, error) {
+	if strings.HasPrefix(url, "tcp://") {
+		return t.transport.framed.roundTrip(ctx, hostOf(url), body, t.oneway)
+	}
+	return t.post(ctx, url, body)
+}
+
+func (t *sendProt) post(ctx context.Context, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-thrift")
+	if t.opts.Credentials != nil {
+		if err := t.opts.Credentials.Apply(req, body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.transport.do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
-	io.Copy(t.recvbuf, resp.Body)
-	return nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("thriftrpc: %s returned %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func hostOf(rawurl string) string {
+	if u, err := url.Parse(rawurl); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawurl
 }
 
-func getSendProt(url func() string, recvbuf *thrift.TMemoryBuffer, compact bool) thrift.TProtocol {
+func getSendProt(url func() string, recvbuf *thrift.TMemoryBuffer, compact bool, opts *ClientOptions) thrift.TProtocol {
 	sendbuf := thrift.NewTMemoryBuffer()
 	var underlying thrift.TProtocol
 	if compact {
@@ -39,12 +163,30 @@ func getSendProt(url func() string, recvbuf *thrift.TMemoryBuffer, compact bool)
 	} else {
 		underlying = thrift.NewTBinaryProtocol(sendbuf, true, true)
 	}
-	return &sendProt{&http.Client{Transport: &http.Transport{}}, url, sendbuf, recvbuf, underlying}
+	p := &sendProt{
+		transport: DefaultTransport,
+		url:       url,
+		sendbuf:   sendbuf,
+		recvbuf:   recvbuf,
+		TProtocol: underlying,
+	}
+	if opts != nil {
+		p.opts = *opts
+		if opts.Transport != nil {
+			p.transport = opts.Transport
+		}
+	}
+	return p
 }
 
 func NewDynamicClientProts(url func() string, compact bool) (recv, send thrift.TProtocol) {
+	return NewDynamicClientProtsWithOptions(url, compact, nil)
+}
+
+// Like NewDynamicClientProts, but with the optional behaviors configured in `opts`.
+func NewDynamicClientProtsWithOptions(url func() string, compact bool, opts *ClientOptions) (recv, send thrift.TProtocol) {
 	recvbuf := thrift.NewTMemoryBuffer()
-	send = getSendProt(url, recvbuf, compact)
+	send = getSendProt(url, recvbuf, compact, opts)
 	if compact {
 		recv = thrift.NewTCompactProtocol(recvbuf)
 	} else {
@@ -54,6 +196,14 @@ func NewDynamicClientProts(url func() string, compact bool) (recv, send thrift.T
 }
 
 // pass these to the generated `NewFooClientProtocol(nil, recv, send)` method.
+//
+// http(s):// urls send thrift-over-http, to a ThriftOverHTTPHandler, while tcp://host:port urls
+// send framed thrift over pooled TCP connections, to a FramedServer.
 func NewClientProts(url string, compact bool) (recv, send thrift.TProtocol) {
 	return NewDynamicClientProts(func() string { return url }, compact)
 }
+
+// Like NewClientProts, but with the optional behaviors configured in `opts`.
+func NewClientProtsWithOptions(url string, compact bool, opts *ClientOptions) (recv, send thrift.TProtocol) {
+	return NewDynamicClientProtsWithOptions(func() string { return url }, compact, opts)
+}
@@ -1,8 +1,10 @@
 package thriftrpc
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
@@ -12,63 +14,167 @@ import (
 type ThriftOverHTTPHandler struct {
 	thrift.TProcessor
 	stats   *report.Recorder
-	buffers sync.Pool
+	buffers bufferPool
+	capture *Capture
+	authn   Authenticator
+	authz   Authorizer
+
+	maxRequestSize    int64
+	responseChunkSize int
 }
 
 func NewThriftOverHTTPHandler(p thrift.TProcessor, stats *report.Recorder) *ThriftOverHTTPHandler {
-	return &ThriftOverHTTPHandler{p, stats, sync.Pool{}}
+	return &ThriftOverHTTPHandler{TProcessor: p, stats: stats, responseChunkSize: DefaultResponseChunkSize}
 }
 
-func (h *ThriftOverHTTPHandler) getBuf() *thrift.TMemoryBuffer {
-	res := h.buffers.Get()
-	if res == nil {
-		return thrift.NewTMemoryBuffer()
-	} else {
-		out := res.(*thrift.TMemoryBuffer)
-		out.Reset()
-		return out
-	}
+// MaxRequestSize rejects requests larger than max bytes with a 413. Defaults to
+// DefaultMaxRequestSize. Must be set before serving.
+func (h *ThriftOverHTTPHandler) MaxRequestSize(max int64) *ThriftOverHTTPHandler {
+	h.maxRequestSize = max
+	return h
+}
+
+// ResponseChunkSize sets how much of a response is buffered before the handler starts sending it
+// to the client, in chunks of about that size. 0 buffers whole responses. Defaults to
+// DefaultResponseChunkSize. Must be set before serving.
+func (h *ThriftOverHTTPHandler) ResponseChunkSize(size int) *ThriftOverHTTPHandler {
+	h.responseChunkSize = size
+	return h
+}
+
+// Capture samples served calls into c, eg to later Replay them. Must be set before serving.
+func (h *ThriftOverHTTPHandler) Capture(c *Capture) *ThriftOverHTTPHandler {
+	h.capture = c
+	return h
+}
+
+// Auth requires callers to authenticate with authn and, if authz is not nil, be authorized by it
+// to call each method. Rejected calls get a 401 or 403 response. Must be set before serving.
+func (h *ThriftOverHTTPHandler) Auth(authn Authenticator, authz Authorizer) *ThriftOverHTTPHandler {
+	h.authn, h.authz = authn, authz
+	return h
 }
 
 func (h *ThriftOverHTTPHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
 	start := time.Now()
-	if req.Method == "POST" {
-		inbuf := h.getBuf()
-		defer h.buffers.Put(inbuf)
-		outbuf := h.getBuf()
-		defer h.buffers.Put(outbuf)
+	if h.stats != nil {
+		defer h.stats.TimeSince("servehttp", start)
+	}
+	if req.Method != "POST" {
+		http.Error(out, "Must POST TBinary encoded thrift RPC", 401)
+		return
+	}
+	defer req.Body.Close()
 
-		inbuf.ReadFrom(req.Body)
-		defer req.Body.Close()
+	max := h.maxRequestSize
+	if max <= 0 {
+		max = DefaultMaxRequestSize
+	}
+	if req.ContentLength > max {
+		h.tooLarge(out, max)
+		return
+	}
+	body := bufio.NewReader(http.MaxBytesReader(out, req.Body, max))
 
-		compact := false
+	info := httpCallInfo(req, int(req.ContentLength))
+	captured := h.capture != nil && h.capture.sample()
 
-		if inbuf.Len() > 0 && inbuf.Bytes()[0] == thrift.COMPACT_PROTOCOL_ID {
-			compact = true
-		}
+	outbuf := h.buffers.get()
+	defer h.buffers.put(outbuf)
+	resp := &responseWriter{w: out, buf: outbuf, chunkSize: h.responseChunkSize}
+	if captured {
+		resp.chunkSize = 0
+	}
 
-		var iprot thrift.TProtocol
-		var oprot thrift.TProtocol
+	var in thrift.TTransport
+	var reqBytes []byte
+	var streamed *requestBody
+	if h.authn == nil && !captured {
+		// nothing needs the raw request, so decode it as it arrives.
+		streamed = newRequestBody(body, req.ContentLength, max)
+		in = streamed
+		reqBytes, _ = body.Peek(1)
+	} else {
+		inbuf := h.buffers.get()
+		defer h.buffers.put(inbuf)
+		if _, err := inbuf.ReadFrom(body); isTooLarge(err) {
+			h.tooLarge(out, max)
+			return
+		} else if err != nil {
+			http.Error(out, err.Error(), 400)
+			return
+		}
+		in = inbuf
+		// reading the request consumes inbuf, but leaves the bytes in place until it is reused.
+		reqBytes = inbuf.Bytes()
+		info.requestSize = inbuf.Len()
+	}
 
-		if compact {
-			iprot = thrift.NewTCompactProtocol(inbuf)
-			oprot = thrift.NewTCompactProtocol(outbuf)
-		} else {
-			iprot = thrift.NewTBinaryProtocol(inbuf, true, true)
-			oprot = thrift.NewTBinaryProtocol(outbuf, true, true)
+	if h.authn != nil {
+		principal, err := h.authn.Authenticate(req, reqBytes)
+		if err != nil {
+			if h.stats != nil {
+				h.stats.Inc("rpc.auth.unauthenticated")
+			}
+			http.Error(out, err.Error(), 401)
+			return
 		}
+		info.principal = principal
+	}
 
-		ok, err := h.Process(iprot, oprot)
+	iprot, oprot := serverProtocols(isCompact(reqBytes), in, resp)
+	var denied string
+	if h.authz != nil {
+		iprot = authzProtocol{iprot, info.principal, h.authz, &denied}
+	}
+	iprot = withCallInfo(iprot, info)
 
-		if ok {
-			outbuf.WriteTo(out)
-		} else {
-			http.Error(out, err.Error(), 500)
+	ok, err := h.Process(iprot, oprot)
+	if denied != "" {
+		if h.stats != nil {
+			h.stats.Inc("rpc.auth.denied." + metricSafe(denied))
 		}
-	} else {
-		http.Error(out, "Must POST TBinary encoded thrift RPC", 401)
+		http.Error(out, err.Error(), 403)
+		return
+	}
+
+	if captured {
+		h.capture.add(start, reqBytes, outbuf.Bytes(), err)
+	}
+
+	switch {
+	case ok:
+		resp.send()
+	case resp.started():
+		// too late for an error status, so cut the response short rather than let it look complete.
+		panic(http.ErrAbortHandler)
+	case streamed != nil && streamed.exceeded():
+		h.tooLarge(out, max)
+	default:
+		http.Error(out, err.Error(), 500)
 	}
+}
+
+func (h *ThriftOverHTTPHandler) tooLarge(out http.ResponseWriter, max int64) {
 	if h.stats != nil {
-		h.stats.TimeSince("servehttp", start)
+		h.stats.Inc("rpc.request_too_large")
+	}
+	http.Error(out, fmt.Sprintf("Request exceeds max size of %d bytes", max), http.StatusRequestEntityTooLarge)
+}
+
+func isTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func isCompact(body []byte) bool {
+	return len(body) > 0 && body[0] == thrift.COMPACT_PROTOCOL_ID
+}
+
+// Uses binary or compact protocol, to match the request, for both the request and the response.
+func serverProtocols(compact bool, in, out thrift.TTransport) (iprot, oprot thrift.TProtocol) {
+	if compact {
+		return thrift.NewTCompactProtocol(in), thrift.NewTCompactProtocol(out)
 	}
+	return thrift.NewTBinaryProtocol(in, true, true), thrift.NewTBinaryProtocol(out, true, true)
 }
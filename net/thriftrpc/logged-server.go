@@ -1,10 +1,10 @@
 package thriftrpc
 
 import (
-	"log"
 	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/foursquare/fsgo/logging"
 	"github.com/foursquare/fsgo/report"
 )
 
@@ -13,15 +13,64 @@ type HasProcessFunc interface {
 	GetProcessorFunction(key string) (processor thrift.TProcessorFunction, ok bool)
 }
 
+var logger = logging.New("thriftrpc")
+
+// Logs at Info if debug logging was asked for when the processor was created, so it shows at the
+// default level, and otherwise at Debug, so it can be turned on at runtime.
+func debugLog(debug bool, msg string, keyvals ...interface{}) {
+	if debug {
+		logger.Info(msg, keyvals...)
+	} else {
+		logger.Debug(msg, keyvals...)
+	}
+}
+
 // Wraps a generated thrift Processor, providing a ServeHTTP method to serve thrift-over-http.
 type LoggedProcessor struct {
 	HasProcessFunc
 	stats *report.Recorder
 	debug bool
+	// set for one of several services multiplexed behind a ServiceMux.
+	service string
+	// prepended to metric names, eg "rpc." or "rpc.<service>." for multiplexed services.
+	prefix string
+	calls  *CallStats
+	access *AccessLog
 }
 
 func AddLogging(p HasProcessFunc, stats *report.Recorder, debug bool) thrift.TProcessor {
-	return LoggedProcessor{p, stats, debug}
+	return *NewLoggedProcessor(p, stats, debug)
+}
+
+// Like AddLogging, but returns the LoggedProcessor itself, eg to serve its CallStats.
+func NewLoggedProcessor(p HasProcessFunc, stats *report.Recorder, debug bool) *LoggedProcessor {
+	return newLoggedProcessor("", p, stats, debug, NewCallStats())
+}
+
+// Like AddLogging, but for one of several services multiplexed behind a ServiceMux: metrics are
+// recorded under rpc.<service>. instead of rpc.
+func AddServiceLogging(service string, p HasProcessFunc, stats *report.Recorder, debug bool) thrift.TProcessor {
+	return *newLoggedProcessor(service, p, stats, debug, NewCallStats())
+}
+
+func newLoggedProcessor(service string, p HasProcessFunc, stats *report.Recorder, debug bool, calls *CallStats) *LoggedProcessor {
+	prefix := "rpc."
+	if service != "" {
+		prefix = "rpc." + service + "."
+	}
+	calls.addMethods(service, p)
+	return &LoggedProcessor{p, stats, debug, service, prefix, calls, nil}
+}
+
+// LogCalls writes structured per-call logs to l. Must be set before processing any calls.
+func (p *LoggedProcessor) LogCalls(l *AccessLog) *LoggedProcessor {
+	p.access = l
+	return p
+}
+
+// CallStats returns the per-method call activity seen by p, which can be served as an admin page.
+func (p LoggedProcessor) CallStats() *CallStats {
+	return p.calls
 }
 
 // Mostly borrowed from generated thrift code `Process` method, but with timing added.
@@ -30,24 +79,30 @@ func (p LoggedProcessor) Process(iprot, oprot thrift.TProtocol) (success bool, e
 	if err != nil {
 		return false, err
 	}
+	start := time.Now()
 
 	if processor, ok := p.GetProcessorFunction(name); ok {
-		if p.debug {
-			log.Println("[rpc]", name)
+		debugLog(p.debug, "call", "method", name)
+		qualified := qualifiedName(p.service, name)
+		p.calls.start(qualified)
+		var declared *exceptionWatcher
+		if p.access != nil {
+			declared = &exceptionWatcher{TProtocol: oprot}
+			oprot = declared
 		}
-		start := time.Now()
 		success, err = processor.Process(seqId, iprot, oprot)
+		dur := time.Now().Sub(start)
+		p.calls.finish(qualified, dur, err)
+		if p.access != nil {
+			p.access.log(qualified, seqId, start, iprot, declared.TProtocol, err, declared.exception)
+		}
 		if p.stats != nil {
 			if err != nil {
-				if p.debug {
-					log.Println("[rpc]", name, err)
-				}
-
-				p.stats.Inc("rpc.error." + name)
+				debugLog(p.debug, "call failed", "method", name, "err", err)
+				p.stats.Inc(p.prefix + "error." + name)
 			}
-			dur := time.Now().Sub(start)
-			p.stats.Time("rpc.timing._all_", dur)
-			p.stats.Time("rpc.timing."+name, dur)
+			p.stats.Time(p.prefix+"timing._all_", dur)
+			p.stats.Time(p.prefix+"timing."+name, dur)
 		}
 		return success, err
 	}
@@ -55,13 +110,12 @@ func (p LoggedProcessor) Process(iprot, oprot thrift.TProtocol) (success bool, e
 	iprot.Skip(thrift.STRUCT)
 	iprot.ReadMessageEnd()
 	e := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+name)
+	p.calls.unknown(qualifiedName(p.service, name), e)
 
-	if p.debug {
-		log.Println("[rpc] unknown function:", name)
-	}
+	debugLog(p.debug, "unknown function", "method", name)
 
 	if p.stats != nil {
-		p.stats.Inc("rpc.error.unknown_function." + name)
+		p.stats.Inc(p.prefix + "error.unknown_function." + name)
 	}
 
 	oprot.WriteMessageBegin(name, thrift.EXCEPTION, seqId)
@@ -69,5 +123,9 @@ func (p LoggedProcessor) Process(iprot, oprot thrift.TProtocol) (success bool, e
 	oprot.WriteMessageEnd()
 	oprot.Flush()
 
+	if p.access != nil {
+		p.access.log(qualifiedName(p.service, name), seqId, start, iprot, oprot, e, "")
+	}
+
 	return true, e
 }
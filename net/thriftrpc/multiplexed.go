@@ -0,0 +1,124 @@
+package thriftrpc
+
+import (
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/foursquare/fsgo/report"
+)
+
+// ServiceMux hosts several thrift services behind a single processor, and thus a single
+// ThriftOverHTTPHandler, dispatching each call on the service name that multiplexed clients
+// (see NewMultiplexedClientProts) prefix to the method name.
+type ServiceMux struct {
+	services map[string]*LoggedProcessor
+	fallback *LoggedProcessor
+	stats    *report.Recorder
+	debug    bool
+	calls    *CallStats
+	access   *AccessLog
+}
+
+func NewServiceMux(stats *report.Recorder, debug bool) *ServiceMux {
+	return &ServiceMux{make(map[string]*LoggedProcessor), nil, stats, debug, NewCallStats(), nil}
+}
+
+// LogCalls writes structured per-call logs for all services, whether registered before or after
+// it, to l. Must be set before processing any calls.
+func (m *ServiceMux) LogCalls(l *AccessLog) *ServiceMux {
+	m.access = l
+	for _, p := range m.services {
+		p.LogCalls(l)
+	}
+	if m.fallback != nil {
+		m.fallback.LogCalls(l)
+	}
+	return m
+}
+
+// CallStats returns the per-method call activity of all registered services, which can be
+// served as an admin page. Methods of multiplexed services are listed as "service:method".
+func (m *ServiceMux) CallStats() *CallStats {
+	return m.calls
+}
+
+// Registers a generated processor as `service`. Calls are logged as by AddLogging, with metrics
+// under rpc.<service>. An empty service is the same as RegisterDefault.
+func (m *ServiceMux) Register(service string, p HasProcessFunc) *ServiceMux {
+	if service == "" {
+		return m.RegisterDefault(p)
+	}
+	m.services[service] = newLoggedProcessor(service, p, m.stats, m.debug, m.calls).LogCalls(m.access)
+	return m
+}
+
+// Registers a processor to handle calls from non-multiplexed clients, which do not prefix a
+// service name. Calls are logged as by AddLogging, with metrics under rpc.
+func (m *ServiceMux) RegisterDefault(p HasProcessFunc) *ServiceMux {
+	m.fallback = newLoggedProcessor("", p, m.stats, m.debug, m.calls).LogCalls(m.access)
+	return m
+}
+
+func (m *ServiceMux) Process(iprot, oprot thrift.TProtocol) (success bool, err thrift.TException) {
+	name, typeId, seqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+
+	service, method := splitServiceName(name)
+	var p *LoggedProcessor
+	if service == "" {
+		p = m.fallback
+	} else {
+		p = m.services[service]
+	}
+
+	if p != nil {
+		stored := thrift.NewStoredMessageProtocol(iprot, method, typeId, seqId)
+		return p.Process(withCallInfo(stored, callInfoOf(iprot)), oprot)
+	}
+
+	iprot.Skip(thrift.STRUCT)
+	iprot.ReadMessageEnd()
+	// without a service, the call was for the default processor, and none is registered.
+	metric, msg := "rpc.error.unknown_function."+metricSafe(method), "Unknown function "+method
+	if service != "" {
+		metric, msg = "rpc.error.unknown_service."+metricSafe(service), "Unknown service "+service
+	}
+	e := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, msg)
+
+	debugLog(m.debug, "unknown service", "service", service, "method", method)
+
+	m.calls.unknown(name, e)
+	if m.stats != nil {
+		m.stats.Inc(metric)
+	}
+
+	oprot.WriteMessageBegin(name, thrift.EXCEPTION, seqId)
+	e.Write(oprot)
+	oprot.WriteMessageEnd()
+	oprot.Flush()
+
+	return true, e
+}
+
+// Splits a multiplexed "service:method" message name. service is empty if name has no prefix.
+func splitServiceName(name string) (service, method string) {
+	if parts := strings.SplitN(name, thrift.MULTIPLEXED_SEPARATOR, 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", name
+}
+
+// Like NewClientProtsWithOptions, but calls are addressed to `service` on a ServiceMux (or any
+// other TMultiplexedProcessor). `opts` may be nil.
+func NewMultiplexedClientProts(url string, compact bool, service string, opts *ClientOptions) (recv, send thrift.TProtocol) {
+	return NewDynamicMultiplexedClientProts(func() string { return url }, compact, service, opts)
+}
+
+// Like NewDynamicClientProtsWithOptions, but calls are addressed to `service` on a ServiceMux (or
+// any other TMultiplexedProcessor). `opts` may be nil.
+func NewDynamicMultiplexedClientProts(url func() string, compact bool, service string, opts *ClientOptions) (recv, send thrift.TProtocol) {
+	recv, send = NewDynamicClientProtsWithOptions(url, compact, opts)
+	return recv, thrift.NewTMultiplexedProtocol(send, service)
+}
@@ -0,0 +1,79 @@
+package thriftrpc
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/foursquare/fsgo/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceMux(t *testing.T) {
+	stats := report.NewRecorder()
+	mux := NewServiceMux(stats, false).
+		Register("users", newFakeProcessor("getUser")).
+		Register("venues", newFakeProcessor("getVenue")).
+		RegisterDefault(newFakeProcessor("ping"))
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(mux, stats))
+	defer ts.Close()
+
+	clientStats := report.NewRecorder()
+	recv, send := NewMultiplexedClientProts(ts.URL, false, "users", &ClientOptions{Stats: clientStats})
+	assertCall(t, recv, send, "getUser")
+	_, err := call(recv, send, "getVenue")
+	assert.NotNil(t, err, "methods of other services should not be reachable")
+
+	recv, send = NewMultiplexedClientProts(ts.URL, true, "venues", nil)
+	assertCall(t, recv, send, "getVenue")
+
+	recv, send = NewClientProts(ts.URL, false)
+	assertCall(t, recv, send, "ping")
+
+	recv, send = NewMultiplexedClientProts(ts.URL, false, "nope", nil)
+	_, err = call(recv, send, "getUser")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, int64(1), stats.GetTimer("rpc.users.timing.getUser").Count())
+	assert.Equal(t, int64(1), stats.GetTimer("rpc.venues.timing.getVenue").Count())
+	assert.Equal(t, int64(1), stats.GetTimer("rpc.timing.ping").Count())
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.users.error.unknown_function.getVenue").Count())
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.error.unknown_service.nope").Count())
+	assert.Equal(t, int64(2), clientStats.GetTimer("rpc.client.users.timing.getUser").Count()+
+		clientStats.GetTimer("rpc.client.users.timing.getVenue").Count())
+}
+
+func TestServiceMuxNoDefault(t *testing.T) {
+	stats := report.NewRecorder()
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(NewServiceMux(stats, false).
+		Register("users", newFakeProcessor("getUser")), stats))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	_, err := call(recv, send, "ping")
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.error.unknown_function.ping").Count())
+	assert.Equal(t, int64(0), stats.GetMeter("rpc.error.unknown_service.").Count())
+}
+
+func TestServiceMuxLogCallsAfterRegister(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewServiceMux(nil, false).
+		Register("users", newFakeProcessor("getUser")).
+		RegisterDefault(newFakeProcessor("ping")).
+		LogCalls(NewAccessLog(&out)).
+		Register("venues", newFakeProcessor("getVenue"))
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(mux, nil))
+	defer ts.Close()
+
+	recv, send := NewMultiplexedClientProts(ts.URL, false, "users", nil)
+	assertCall(t, recv, send, "getUser")
+	recv, send = NewMultiplexedClientProts(ts.URL, false, "venues", nil)
+	assertCall(t, recv, send, "getVenue")
+	recv, send = NewClientProts(ts.URL, false)
+	assertCall(t, recv, send, "ping")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 3, len(lines), "services registered before LogCalls should be logged too")
+}
@@ -0,0 +1,196 @@
+package thriftrpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+type ReplayResult struct {
+	Call     CapturedCall
+	Response []byte
+	Err      error
+	// Differences between the captured and replayed responses, by field path, eg
+	// `0.3[1]: "a" != "b"`. Empty if the responses match.
+	Diffs []string
+}
+
+func (r ReplayResult) Matched() bool {
+	return r.Err == nil && len(r.Diffs) == 0
+}
+
+// Replay re-sends captured calls, in order, to the server at url, which may be any url a client
+// accepts, and compares each response to the captured one field by field. `opts` may be nil.
+func Replay(calls []CapturedCall, url string, opts *ClientOptions) []ReplayResult {
+	p := getSendProt(func() string { return url }, nil, false, opts).(*sendProt)
+
+	res := make([]ReplayResult, len(calls))
+	for i, call := range calls {
+		res[i].Call = call
+		p.oneway = isOneway(call.Request)
+		res[i].Response, res[i].Err = p.send(context.Background(), url, call.Request)
+		if res[i].Err == nil {
+			res[i].Diffs = diffMessages(call.Response, res[i].Response)
+		}
+	}
+	return res
+}
+
+func isOneway(body []byte) bool {
+	_, typeId, _, err := readProtocol(body).ReadMessageBegin()
+	return err == nil && typeId == thrift.ONEWAY
+}
+
+func readProtocol(body []byte) thrift.TProtocol {
+	trans := thrift.NewStreamTransportR(bytes.NewReader(body))
+	if len(body) > 0 && body[0] == thrift.COMPACT_PROTOCOL_ID {
+		return thrift.NewTCompactProtocol(trans)
+	}
+	return thrift.NewTBinaryProtocol(trans, true, true)
+}
+
+// Compares two encoded thrift messages without knowing their types, returning the paths of
+// fields that differ.
+func diffMessages(a, b []byte) []string {
+	fa, fb := flattenMessage(a), flattenMessage(b)
+
+	var paths []string
+	for k := range fa {
+		paths = append(paths, k)
+	}
+	for k := range fb {
+		if _, ok := fa[k]; !ok {
+			paths = append(paths, k)
+		}
+	}
+	sort.Strings(paths)
+
+	var diffs []string
+	for _, k := range paths {
+		va, oka := fa[k]
+		vb, okb := fb[k]
+		if !oka {
+			va = "<missing>"
+		}
+		if !okb {
+			vb = "<missing>"
+		}
+		if va != vb {
+			diffs = append(diffs, fmt.Sprintf("%s: %s != %s", k, va, vb))
+		}
+	}
+	return diffs
+}
+
+// Decodes a thrift message into a map of field path to value. Anything that can't be decoded is
+// recorded under the "error" path.
+func flattenMessage(body []byte) map[string]string {
+	res := make(map[string]string)
+	prot := readProtocol(body)
+	name, typeId, _, err := prot.ReadMessageBegin()
+	if err != nil {
+		res["error"] = err.Error()
+		return res
+	}
+	res["message"] = fmt.Sprintf("%s %d", name, typeId)
+	if err := flattenValue(prot, thrift.STRUCT, "", res); err != nil {
+		res["error"] = err.Error()
+	}
+	return res
+}
+
+func flattenValue(prot thrift.TProtocol, t thrift.TType, path string, res map[string]string) error {
+	var v interface{}
+	var err error
+
+	switch t {
+	case thrift.STRUCT:
+		if _, err := prot.ReadStructBegin(); err != nil {
+			return err
+		}
+		for {
+			_, ft, id, err := prot.ReadFieldBegin()
+			if err != nil {
+				return err
+			}
+			if ft == thrift.STOP {
+				break
+			}
+			fieldPath := strconv.Itoa(int(id))
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			if err := flattenValue(prot, ft, fieldPath, res); err != nil {
+				return err
+			}
+			if err := prot.ReadFieldEnd(); err != nil {
+				return err
+			}
+		}
+		return prot.ReadStructEnd()
+	case thrift.LIST, thrift.SET:
+		var et thrift.TType
+		var size int
+		if t == thrift.LIST {
+			et, size, err = prot.ReadListBegin()
+		} else {
+			et, size, err = prot.ReadSetBegin()
+		}
+		if err != nil {
+			return err
+		}
+		res[path] = fmt.Sprintf("<%d items>", size)
+		for i := 0; i < size; i++ {
+			if err := flattenValue(prot, et, fmt.Sprintf("%s[%d]", path, i), res); err != nil {
+				return err
+			}
+		}
+		if t == thrift.LIST {
+			return prot.ReadListEnd()
+		}
+		return prot.ReadSetEnd()
+	case thrift.MAP:
+		kt, vt, size, err := prot.ReadMapBegin()
+		if err != nil {
+			return err
+		}
+		res[path] = fmt.Sprintf("<%d entries>", size)
+		for i := 0; i < size; i++ {
+			// keys are flattened like any other value, then used to name the entry.
+			keys := make(map[string]string)
+			if err := flattenValue(prot, kt, "", keys); err != nil {
+				return err
+			}
+			if err := flattenValue(prot, vt, fmt.Sprintf("%s{%s}", path, keys[""]), res); err != nil {
+				return err
+			}
+		}
+		return prot.ReadMapEnd()
+	case thrift.BOOL:
+		v, err = prot.ReadBool()
+	case thrift.BYTE:
+		v, err = prot.ReadByte()
+	case thrift.I16:
+		v, err = prot.ReadI16()
+	case thrift.I32:
+		v, err = prot.ReadI32()
+	case thrift.I64:
+		v, err = prot.ReadI64()
+	case thrift.DOUBLE:
+		v, err = prot.ReadDouble()
+	case thrift.STRING:
+		v, err = prot.ReadString()
+		v = strconv.Quote(v.(string))
+	default:
+		return fmt.Errorf("unknown type %d at %q", t, path)
+	}
+	if err != nil {
+		return err
+	}
+	res[path] = fmt.Sprint(v)
+	return nil
+}
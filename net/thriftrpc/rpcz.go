@@ -0,0 +1,184 @@
+package thriftrpc
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/foursquare/fsgo/adminz"
+	"github.com/rcrowley/go-metrics"
+)
+
+// Generated thrift Processors also have `ProcessorMap`, which lets us list methods before they are called.
+type HasProcessorMap interface {
+	ProcessorMap() map[string]thrift.TProcessorFunction
+}
+
+// How many recent errors CallStats keeps.
+const recentErrorCount = 50
+
+// CallStats tracks per-method activity of one or more LoggedProcessors for introspection, and
+// serves it as an HTML page, or JSON if requested with ?format=json.
+type CallStats struct {
+	sync.Mutex
+	started time.Time
+	methods map[string]*methodStats
+	// ring buffer of the most recent errors; the next one is written at nextError.
+	errors    []CallError
+	nextError int
+}
+
+type methodStats struct {
+	calls    int64
+	errors   int64
+	inFlight int64
+	latency  metrics.Histogram
+}
+
+type CallError struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Error  string    `json:"error"`
+}
+
+// A point-in-time summary of a single method's activity.
+type MethodSummary struct {
+	Method   string  `json:"method"`
+	Calls    int64   `json:"calls"`
+	Errors   int64   `json:"errors"`
+	InFlight int64   `json:"in_flight"`
+	P50      float64 `json:"p50_ms"`
+	P90      float64 `json:"p90_ms"`
+	P99      float64 `json:"p99_ms"`
+	Max      float64 `json:"max_ms"`
+}
+
+type CallStatsSummary struct {
+	Since   time.Time       `json:"since"`
+	Methods []MethodSummary `json:"methods"`
+	Errors  []CallError     `json:"recent_errors"`
+}
+
+func NewCallStats() *CallStats {
+	return &CallStats{started: time.Now(), methods: make(map[string]*methodStats)}
+}
+
+// Internal helper to find or create the stats for method. MUST be called while holding c.Lock.
+func (c *CallStats) get(method string) *methodStats {
+	m, ok := c.methods[method]
+	if !ok {
+		m = &methodStats{latency: metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))}
+		c.methods[method] = m
+	}
+	return m
+}
+
+// Lists the methods of p, if it is able to, so they show up before they are first called.
+func (c *CallStats) addMethods(service string, p HasProcessFunc) {
+	if known, ok := p.(HasProcessorMap); ok {
+		c.Lock()
+		defer c.Unlock()
+		for name := range known.ProcessorMap() {
+			c.get(qualifiedName(service, name))
+		}
+	}
+}
+
+func (c *CallStats) start(method string) {
+	c.Lock()
+	defer c.Unlock()
+	c.get(method).inFlight++
+}
+
+func (c *CallStats) finish(method string, dur time.Duration, err error) {
+	c.Lock()
+	defer c.Unlock()
+	m := c.get(method)
+	m.inFlight--
+	m.calls++
+	m.latency.Update(int64(dur))
+	if err != nil {
+		m.errors++
+		c.addError(method, err)
+	}
+}
+
+// Internal helper to remember a recent error. MUST be called while holding c.Lock.
+func (c *CallStats) addError(method string, err error) {
+	e := CallError{time.Now(), method, err.Error()}
+	if len(c.errors) < recentErrorCount {
+		c.errors = append(c.errors, e)
+	} else {
+		c.errors[c.nextError] = e
+	}
+	c.nextError = (c.nextError + 1) % recentErrorCount
+}
+
+// Calls to unknown methods only show up in the recent errors, so that garbage requests can't
+// grow the method list without bound.
+func (c *CallStats) unknown(method string, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.addError(method, err)
+}
+
+// Summary returns the current stats for every known method, sorted by name, and the recent
+// errors, most recent first.
+func (c *CallStats) Summary() CallStatsSummary {
+	c.Lock()
+	defer c.Unlock()
+
+	res := CallStatsSummary{Since: c.started, Methods: make([]MethodSummary, 0, len(c.methods))}
+	ms := float64(time.Millisecond)
+	for name, m := range c.methods {
+		h := m.latency.Snapshot()
+		ps := h.Percentiles([]float64{0.5, 0.9, 0.99})
+		res.Methods = append(res.Methods, MethodSummary{
+			name, m.calls, m.errors, m.inFlight, ps[0] / ms, ps[1] / ms, ps[2] / ms, float64(h.Max()) / ms,
+		})
+	}
+	sort.Slice(res.Methods, func(i, j int) bool { return res.Methods[i].Method < res.Methods[j].Method })
+
+	res.Errors = make([]CallError, 0, len(c.errors))
+	for i := range c.errors {
+		res.Errors = append(res.Errors, c.errors[(c.nextError-1-i+2*len(c.errors))%len(c.errors)])
+	}
+	return res
+}
+
+var callStatsTemplate = template.Must(template.New("rpcz").Parse(`<h2>Methods</h2>
+<p>Since {{.Since.Format "2006-01-02 15:04:05 MST"}}. Latencies in milliseconds.</p>
+<table>
+<tr><th>Method</th><th>Calls</th><th>Errors</th><th>In flight</th><th>p50</th><th>p90</th><th>p99</th><th>Max</th></tr>
+{{range .Methods}}<tr><td>{{.Method}}</td><td>{{.Calls}}</td><td>{{.Errors}}</td><td>{{.InFlight}}</td><td>{{printf "%.2f" .P50}}</td><td>{{printf "%.2f" .P90}}</td><td>{{printf "%.2f" .P99}}</td><td>{{printf "%.2f" .Max}}</td></tr>
+{{end}}</table>
+<h2>Recent errors</h2>
+<table>
+<tr><th>Time</th><th>Method</th><th>Error</th></tr>
+{{range .Errors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05.000"}}</td><td>{{.Method}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+`))
+
+func (c *CallStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	summary := c.Summary()
+	adminz.Render(w, r, "rpcz", summary, func(w io.Writer) {
+		callStatsTemplate.Execute(w, summary)
+	})
+}
+
+// Describes the page for the adminz index.
+func (c *CallStats) AdminDescription() string {
+	return "Per-method RPC calls, errors, latencies and recent failures"
+}
+
+// The name a method of a multiplexed service is tracked under.
+func qualifiedName(service, method string) string {
+	if service == "" {
+		return method
+	}
+	return service + thrift.MULTIPLEXED_SEPARATOR + method
+}
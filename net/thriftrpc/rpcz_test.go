@@ -0,0 +1,53 @@
+package thriftrpc
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallStats(t *testing.T) {
+	p := NewLoggedProcessor(newFakeProcessor("ping", "pong"), nil, false)
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(p, nil))
+	defer ts.Close()
+
+	recv, send := NewClientProts(ts.URL, false)
+	assertCall(t, recv, send, "ping")
+	assertCall(t, recv, send, "ping")
+	_, err := call(recv, send, "nope")
+	assert.NotNil(t, err)
+
+	summary := p.CallStats().Summary()
+	assert.Equal(t, 2, len(summary.Methods), "should list known methods, and only those")
+	assert.Equal(t, "ping", summary.Methods[0].Method)
+	assert.Equal(t, int64(2), summary.Methods[0].Calls)
+	assert.Equal(t, int64(0), summary.Methods[0].InFlight)
+	assert.Equal(t, "pong", summary.Methods[1].Method)
+	assert.Equal(t, int64(0), summary.Methods[1].Calls)
+	assert.Equal(t, 1, len(summary.Errors))
+	assert.Equal(t, "nope", summary.Errors[0].Method)
+
+	w := httptest.NewRecorder()
+	p.CallStats().ServeHTTP(w, httptest.NewRequest("GET", "/rpcz?format=json", nil))
+	var decoded CallStatsSummary
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+	assert.Equal(t, summary.Methods, decoded.Methods)
+
+	w = httptest.NewRecorder()
+	p.CallStats().ServeHTTP(w, httptest.NewRequest("GET", "/rpcz", nil))
+	assert.True(t, strings.Contains(w.Body.String(), "<td>ping</td><td>2</td>"))
+}
+
+func TestCallStatsRecentErrors(t *testing.T) {
+	c := NewCallStats()
+	for i := 0; i < recentErrorCount+5; i++ {
+		c.unknown(string(rune('a'+i%26)), assert.AnError)
+	}
+	errors := c.Summary().Errors
+	assert.Equal(t, recentErrorCount, len(errors))
+	assert.Equal(t, string(rune('a'+(recentErrorCount+4)%26)), errors[0].Method, "most recent should be first")
+	assert.Equal(t, 0, len(c.Summary().Methods))
+}
@@ -0,0 +1,168 @@
+package thriftrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/foursquare/fsgo/report"
+)
+
+// TransportOptions configures how clients connect to servers. Zero values use Go's defaults,
+// except as noted by DefaultTransportOptions.
+type TransportOptions struct {
+	// Max idle connections kept across all hosts, and to any single host.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// How long an idle connection is kept before being closed.
+	IdleConnTimeout time.Duration
+
+	DialTimeout time.Duration
+	// TCP keep-alive period for open connections.
+	KeepAlive time.Duration
+	// Disables connection reuse entirely, making a new connection for every request.
+	DisableKeepAlives bool
+
+	TLSHandshakeTimeout time.Duration
+	// How long to wait for response headers after sending a request. Zero means no limit.
+	ResponseHeaderTimeout time.Duration
+
+	// Attempt HTTP/2 for https:// urls, even though a custom TLS config or dialer is in use.
+	HTTP2 bool
+	// Used for https:// urls. Cloned, not modified.
+	TLSConfig *tls.Config
+	// If set, the PEM encoded certificate and key presented to servers that require client certs.
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// The options used by DefaultTransport.
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		DialTimeout:         5 * time.Second,
+		KeepAlive:           30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		HTTP2:               true,
+	}
+}
+
+// Transport holds the connection pools used by clients: an http.Client for thrift-over-http and a
+// pool of TCP connections for framed thrift. Clients sharing a Transport share its pools.
+type Transport struct {
+	client *http.Client
+	framed *framedPool
+	stats  *report.Recorder
+
+	// open http connections, reported as the rpc.client.pool.open gauge.
+	open int64
+}
+
+// Used by clients that do not set ClientOptions.Transport.
+var DefaultTransport, _ = NewTransport(DefaultTransportOptions(), nil)
+
+// Creates a Transport with the given options. If stats is non-nil, connection pool activity is
+// reported under rpc.client.pool.
+func NewTransport(opts TransportOptions, stats *report.Recorder) (*Transport, error) {
+	t := &Transport{stats: stats}
+
+	tlsConfig := &tls.Config{}
+	if opts.TLSConfig != nil {
+		tlsConfig = opts.TLSConfig.Clone()
+	}
+	if opts.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+	t.client = &http.Client{Transport: &http.Transport{
+		DialContext:           t.countingDialer(dialer),
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		DisableKeepAlives:     opts.DisableKeepAlives,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     opts.HTTP2,
+	}}
+
+	maxIdle := opts.MaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = http.DefaultMaxIdleConnsPerHost
+	}
+	if opts.DisableKeepAlives {
+		maxIdle = 0
+	}
+	t.framed = newFramedPool(maxIdle, opts.IdleConnTimeout, t.countingDialer(dialer), stats)
+	return t, nil
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Wraps dialer to keep count of open connections in the rpc.client.pool.open gauge.
+func (t *Transport) countingDialer(dialer *net.Dialer) dialFunc {
+	if t.stats == nil {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			t.stats.Inc("rpc.client.pool.dial_error")
+			return nil, err
+		}
+		t.stats.GetGuage("rpc.client.pool.open").Update(atomic.AddInt64(&t.open, 1))
+		return &countedConn{Conn: c, t: t}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	t      *Transport
+	closed int32
+}
+
+// SyscallConn exposes the underlying connection, eg for peerClosed.
+func (c *countedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("thriftrpc: connection has no underlying file descriptor")
+	}
+	return sc.SyscallConn()
+}
+
+func (c *countedConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.t.stats.GetGuage("rpc.client.pool.open").Update(atomic.AddInt64(&c.t.open, -1))
+	}
+	return c.Conn.Close()
+}
+
+// Sends req, noting whether it used a new or reused pooled connection.
+func (t *Transport) do(req *http.Request) (*http.Response, error) {
+	if t.stats != nil {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					t.stats.Inc("rpc.client.pool.reused")
+				} else {
+					t.stats.Inc("rpc.client.pool.new")
+				}
+			},
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	}
+	return t.client.Do(req)
+}
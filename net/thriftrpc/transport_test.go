@@ -0,0 +1,34 @@
+package thriftrpc
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/foursquare/fsgo/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedTransport(t *testing.T) {
+	ts := httptest.NewServer(NewThriftOverHTTPHandler(AddLogging(newFakeProcessor("ping"), nil, false), nil))
+	defer ts.Close()
+
+	stats := report.NewRecorder()
+	transport, err := NewTransport(DefaultTransportOptions(), stats)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		recv, send := NewClientProtsWithOptions(ts.URL, false, &ClientOptions{Transport: transport})
+		assertCall(t, recv, send, "ping")
+	}
+	assert.Equal(t, int64(1), stats.GetMeter("rpc.client.pool.new").Count())
+	assert.Equal(t, int64(2), stats.GetMeter("rpc.client.pool.reused").Count(), "clients should share connections")
+	assert.Equal(t, int64(1), stats.GetGuage("rpc.client.pool.open").Value())
+}
+
+func TestTransportBadClientCert(t *testing.T) {
+	opts := DefaultTransportOptions()
+	opts.ClientCertFile = "/does/not/exist.pem"
+	opts.ClientKeyFile = "/does/not/exist.key"
+	_, err := NewTransport(opts, nil)
+	assert.NotNil(t, err)
+}
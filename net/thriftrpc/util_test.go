@@ -0,0 +1,112 @@
+package thriftrpc
+
+import (
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+// A hand-rolled stand-in for a generated processor, with functions that reply with a fixed string.
+type fakeProcessor map[string]thrift.TProcessorFunction
+
+func (f fakeProcessor) GetProcessorFunction(key string) (thrift.TProcessorFunction, bool) {
+	p, ok := f[key]
+	return p, ok
+}
+
+func (f fakeProcessor) ProcessorMap() map[string]thrift.TProcessorFunction {
+	return f
+}
+
+type replyFunc struct {
+	name  string
+	reply string
+}
+
+func (r replyFunc) Process(seqId int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	iprot.Skip(thrift.STRUCT)
+	iprot.ReadMessageEnd()
+
+	oprot.WriteMessageBegin(r.name, thrift.REPLY, seqId)
+	oprot.WriteStructBegin("result")
+	oprot.WriteFieldBegin("success", thrift.STRING, 0)
+	oprot.WriteString(r.reply)
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	oprot.WriteMessageEnd()
+	return true, oprot.Flush()
+}
+
+// Replies with an exception declared in the IDL, as a generated processor does: as a field of the
+// result, returning no error.
+type declaredExceptionFunc struct {
+	name      string
+	exception string
+}
+
+func (d declaredExceptionFunc) Process(seqId int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	iprot.Skip(thrift.STRUCT)
+	iprot.ReadMessageEnd()
+
+	oprot.WriteMessageBegin(d.name, thrift.REPLY, seqId)
+	oprot.WriteStructBegin(d.name + "_result")
+	oprot.WriteFieldBegin("ex", thrift.STRUCT, 1)
+	oprot.WriteStructBegin(d.exception)
+	oprot.WriteFieldBegin("message", thrift.STRING, 1)
+	oprot.WriteString("failed")
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	oprot.WriteMessageEnd()
+	return true, oprot.Flush()
+}
+
+func newFakeProcessor(names ...string) fakeProcessor {
+	f := make(fakeProcessor)
+	for _, name := range names {
+		f[name] = replyFunc{name, "reply:" + name}
+	}
+	return f
+}
+
+// Makes a call with no arguments, as a generated client would, and returns the string reply.
+func call(recv, send thrift.TProtocol, name string) (string, error) {
+	send.WriteMessageBegin(name, thrift.CALL, 1)
+	send.WriteStructBegin("args")
+	send.WriteFieldStop()
+	send.WriteStructEnd()
+	send.WriteMessageEnd()
+	if err := send.Flush(); err != nil {
+		return "", err
+	}
+
+	_, typeId, _, err := recv.ReadMessageBegin()
+	if err != nil {
+		return "", err
+	}
+	if typeId == thrift.EXCEPTION {
+		e := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "")
+		e, _ = e.Read(recv)
+		recv.ReadMessageEnd()
+		return "", e
+	}
+	recv.ReadStructBegin()
+	recv.ReadFieldBegin()
+	res, err := recv.ReadString()
+	recv.ReadFieldEnd()
+	recv.ReadFieldBegin()
+	recv.ReadStructEnd()
+	recv.ReadMessageEnd()
+	return res, err
+}
+
+func assertCall(t *testing.T, recv, send thrift.TProtocol, name string) {
+	res, err := call(recv, send, name)
+	assert.Nil(t, err)
+	assert.Equal(t, "reply:"+name, res)
+}
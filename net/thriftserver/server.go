@@ -0,0 +1,292 @@
+// Package thriftserver wires thriftrpc, adminz, report and discovery together, so a service
+// only has to provide its processor and a Config.
+package thriftserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/foursquare/fsgo/adminz"
+	"github.com/foursquare/fsgo/logging"
+	"github.com/foursquare/fsgo/net/discovery"
+	"github.com/foursquare/fsgo/net/thriftrpc"
+	"github.com/foursquare/fsgo/report"
+)
+
+var logger = logging.New("thriftserver")
+
+type Config struct {
+	// Name the service is registered under in discovery.
+	Name string
+	// Address to listen on, eg ":8080".
+	Addr string
+	// If set, adminz pages are served on their own listener at this address, eg "localhost:8081",
+	// rather than alongside thrift on Addr.
+	AdminAddr string
+
+	// The generated processor to serve. May be nil if Services is set.
+	Processor thriftrpc.HasProcessFunc
+	// If set, these processors are served behind a thriftrpc.ServiceMux, by service name, with
+	// Processor handling calls from non-multiplexed clients.
+	Services map[string]thriftrpc.HasProcessFunc
+	// Path thrift is served on. Defaults to "/".
+	Path string
+	// Log every call.
+	Debug bool
+
+	// Graphite "host:port/prefix" to report metrics to, if set.
+	Graphite string
+
+	// Killfiles to watch. Defaults to adminz.Killfiles(port). An empty, non-nil list watches none.
+	Killfiles        []string
+	KillfileInterval time.Duration
+	// Passed to adminz.
+	Healthy  func() bool
+	Servicez func() interface{}
+	// Run, in order, before the server is registered and reports itself healthy.
+	Warmups []func() error
+
+	// ZooKeeper connection string and base path to register in, if registering at all.
+	ZooKeeper     string
+	DiscoveryPath string
+	// Used instead of connecting to ZooKeeper, if set.
+	Discovery *discovery.ServiceDiscovery
+	// Address to register. Defaults to the hostname.
+	AdvertiseAddress string
+
+	// How long to wait after unregistering, for clients to notice, before closing the listener.
+	DrainDelay time.Duration
+	// How long to wait for in-flight requests during shutdown. Defaults to 10 seconds.
+	ShutdownTimeout time.Duration
+}
+
+// Server serves a thrift processor over HTTP, with adminz pages and metrics on the same port, and
+// keeps it registered in discovery while it is not paused by a killfile.
+type Server struct {
+	Config
+	Stats     *report.Recorder
+	Admin     *adminz.Adminz
+	Discovery *discovery.ServiceDiscovery
+
+	mux   *http.ServeMux
+	http  *http.Server
+	conn  discovery.Conn
+	calls *thriftrpc.CallStats
+
+	sync.Mutex
+	registration *discovery.ServiceInstance
+}
+
+// New sets up a Server from cfg. Call ListenAndServe, Serve or Run to start it.
+func New(cfg Config) (*Server, error) {
+	if cfg.Processor == nil && len(cfg.Services) == 0 {
+		return nil, errors.New("thriftserver: no Processor or Services to serve")
+	}
+	var graphiteServer, graphitePrefix string
+	if cfg.Graphite != "" {
+		var err error
+		if graphiteServer, graphitePrefix, err = parseGraphite(cfg.Graphite); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 10 * time.Second
+	}
+	if cfg.AdvertiseAddress == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		cfg.AdvertiseAddress = host
+	}
+
+	s := &Server{Config: cfg, Stats: report.NewRecorder(), mux: http.NewServeMux()}
+	if cfg.Graphite != "" {
+		s.Stats.ReportToServer(graphiteServer, graphitePrefix)
+	}
+
+	var processor *thriftrpc.ThriftOverHTTPHandler
+	if len(cfg.Services) > 0 {
+		m := thriftrpc.NewServiceMux(s.Stats, cfg.Debug)
+		for name, p := range cfg.Services {
+			m.Register(name, p)
+		}
+		if cfg.Processor != nil {
+			m.RegisterDefault(cfg.Processor)
+		}
+		s.calls = m.CallStats()
+		processor = thriftrpc.NewThriftOverHTTPHandler(m, s.Stats)
+	} else {
+		p := thriftrpc.NewLoggedProcessor(cfg.Processor, s.Stats, cfg.Debug)
+		s.calls = p.CallStats()
+		processor = thriftrpc.NewThriftOverHTTPHandler(*p, s.Stats)
+	}
+	s.mux.Handle(cfg.Path, processor)
+
+	s.Discovery = cfg.Discovery
+	if s.Discovery == nil && cfg.ZooKeeper != "" {
+		d, conn, err := discovery.NewServiceDiscoveryAndConn(cfg.ZooKeeper, cfg.DiscoveryPath)
+		if err != nil {
+			return nil, err
+		}
+		d.MaintainRegistrations()
+		s.Discovery, s.conn = d, conn
+	}
+
+	s.Admin = adminz.New().
+		Healthy(cfg.Healthy).
+		Servicez(cfg.Servicez).
+		KillfileInterval(cfg.KillfileInterval).
+		OnPause(s.unregister).
+		OnResume(s.register).
+		Handle("/rpcz", s.calls).
+		Handle("/statz", s.Stats)
+	if s.Discovery != nil {
+		s.Admin.Handle("/discoveryz", s.Discovery)
+	}
+	for _, w := range cfg.Warmups {
+		s.Admin.Warmup(w)
+	}
+	if cfg.AdminAddr != "" {
+		s.Admin.Listen(cfg.AdminAddr)
+	} else {
+		s.Admin.ServeMux(s.mux)
+	}
+
+	s.http = &http.Server{Handler: s.mux}
+	return s, nil
+}
+
+// Splits a Graphite config into its server and prefix, checking the server resolves, as the
+// Recorder would otherwise panic on it.
+func parseGraphite(graphite string) (server, prefix string, err error) {
+	parts := strings.Split(graphite, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("thriftserver: Graphite must be host:port/prefix, not %q", graphite)
+	}
+	if _, err := net.ResolveTCPAddr("tcp", parts[0]); err != nil {
+		return "", "", fmt.Errorf("thriftserver: bad Graphite server: %v", err)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Mux is the mux thrift, and adminz unless AdminAddr is set, are served on, for adding other
+// handlers before serving.
+func (s *Server) Mux() *http.ServeMux {
+	return s.mux
+}
+
+// Listens on Addr and serves until Shutdown.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Starts adminz, which registers in discovery once warmed up, unless paused by a killfile, and
+// serves on l, which must be a TCP listener, until Shutdown, at which point it returns nil. Fails
+// if adminz can't listen on AdminAddr.
+func (s *Server) Serve(l net.Listener) error {
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("thriftserver: can only serve on TCP, not %s", l.Addr().Network())
+	}
+	port := addr.Port
+	if s.Discovery != nil {
+		s.Lock()
+		s.registration = discovery.NewSimpleServiceInstance(s.Name, s.AdvertiseAddress, port)
+		s.Unlock()
+	}
+
+	killfiles := s.Killfiles
+	if killfiles == nil {
+		killfiles = adminz.Killfiles(port)
+	}
+	if err := s.Admin.KillfilePaths(killfiles).StartErr(); err != nil {
+		l.Close()
+		return err
+	}
+
+	logger.Info("serving", "name", s.Name, "addr", l.Addr())
+	if err := s.http.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Run serves on Addr until SIGINT or SIGTERM, then shuts down.
+func (s *Server) Run() error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServe()
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-sigs:
+		logger.Info("shutting down", "signal", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown stops adminz, which drains the server and unregisters it from discovery, waits
+// DrainDelay for clients to notice, then stops accepting connections and waits for in-flight
+// requests to finish or ctx to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Admin.Stop()
+
+	select {
+	case <-time.After(s.DrainDelay):
+	case <-ctx.Done():
+	}
+
+	err := s.http.Shutdown(ctx)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return err
+}
+
+func (s *Server) register() {
+	s.Lock()
+	defer s.Unlock()
+	if s.registration == nil {
+		return
+	}
+	if err := s.Discovery.Resume(s.registration); err != nil {
+		logger.Error("failed to register in discovery", "err", err)
+	}
+}
+
+func (s *Server) unregister() {
+	s.Lock()
+	defer s.Unlock()
+	if s.registration == nil {
+		return
+	}
+	if err := s.Discovery.Pause(s.registration); err != nil {
+		logger.Error("failed to unregister from discovery", "err", err)
+	}
+}
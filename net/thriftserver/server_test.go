@@ -0,0 +1,288 @@
+package thriftserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/curator-go/curator"
+	"github.com/foursquare/fsgo/adminz"
+	"github.com/foursquare/fsgo/net/discovery"
+	"github.com/foursquare/fsgo/net/thriftrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// Replies to "ping" with "pong".
+type pingProcessor struct{}
+
+func (pingProcessor) GetProcessorFunction(key string) (thrift.TProcessorFunction, bool) {
+	return pingFunc{}, key == "ping"
+}
+
+type pingFunc struct{}
+
+func (pingFunc) Process(seqId int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	iprot.Skip(thrift.STRUCT)
+	iprot.ReadMessageEnd()
+	oprot.WriteMessageBegin("ping", thrift.REPLY, seqId)
+	oprot.WriteStructBegin("result")
+	oprot.WriteFieldBegin("success", thrift.STRING, 0)
+	oprot.WriteString("pong")
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	oprot.WriteMessageEnd()
+	return true, oprot.Flush()
+}
+
+func ping(url string) (string, error) {
+	recv, send := thriftrpc.NewClientProts(url, false)
+	send.WriteMessageBegin("ping", thrift.CALL, 1)
+	send.WriteStructBegin("args")
+	send.WriteFieldStop()
+	send.WriteStructEnd()
+	send.WriteMessageEnd()
+	if err := send.Flush(); err != nil {
+		return "", err
+	}
+	if _, _, _, err := recv.ReadMessageBegin(); err != nil {
+		return "", err
+	}
+	recv.ReadStructBegin()
+	recv.ReadFieldBegin()
+	return recv.ReadString()
+}
+
+func status(url string) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thriftserver")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	killfile := filepath.Join(dir, "kill")
+
+	s, err := New(Config{
+		Name:             "test",
+		Processor:        pingProcessor{},
+		Path:             "/thrift",
+		Killfiles:        []string{killfile},
+		KillfileInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	base := "http://" + l.Addr().String()
+
+	res, err := ping(base + "/thrift")
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", res)
+	assert.Equal(t, 200, status(base+"/healthz"))
+	assert.Equal(t, 200, status(base+"/statz"))
+	assert.Equal(t, 200, status(base+"/rpcz"))
+
+	ioutil.WriteFile(killfile, nil, 0644)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 503, status(base+"/healthz"))
+	os.Remove(killfile)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 200, status(base+"/healthz"))
+
+	assert.Nil(t, s.Shutdown(context.Background()))
+	assert.Nil(t, <-done)
+}
+
+func TestServerWithoutKillfiles(t *testing.T) {
+	s, err := New(Config{Name: "test", Services: map[string]thriftrpc.HasProcessFunc{"a": pingProcessor{}}, Killfiles: []string{}})
+	assert.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	assert.Equal(t, 200, status("http://"+l.Addr().String()+"/healthz"), "should start serving without any killfiles")
+}
+
+func TestServerWarmup(t *testing.T) {
+	warm := make(chan bool)
+	s, err := New(Config{
+		Name:      "test",
+		Processor: pingProcessor{},
+		Killfiles: []string{},
+		Warmups:   []func() error{func() error { <-warm; return nil }},
+	})
+	assert.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+	health := "http://" + l.Addr().String() + "/healthz"
+
+	for i := 0; i < 100 && status(health) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 503, status(health), "unhealthy until warmed up")
+	close(warm)
+	for i := 0; i < 100 && s.Admin.State() != adminz.Serving; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 200, status(health))
+}
+
+func TestServerAdminAddr(t *testing.T) {
+	s, err := New(Config{Name: "test", Processor: pingProcessor{}, Killfiles: []string{}, AdminAddr: "127.0.0.1:0"})
+	assert.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	for i := 0; i < 100 && s.Admin.Addr() == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	res, err := ping("http://" + l.Addr().String() + "/")
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", res)
+	assert.Equal(t, 200, status("http://"+s.Admin.Addr()+"/healthz"))
+	assert.Equal(t, 200, status("http://"+s.Admin.Addr()+"/rpcz"))
+	assert.NotEqual(t, 200, status("http://"+l.Addr().String()+"/healthz"), "adminz isn't served with thrift")
+}
+
+// A discovery.Conn that keeps registrations in memory, in place of ZooKeeper.
+type fakeConn struct {
+	discovery.Conn
+	sync.Mutex
+	nodes map[string]bool
+}
+
+func (c *fakeConn) Create() curator.CreateBuilder { return fakeCreate{c: c} }
+func (c *fakeConn) Delete() curator.DeleteBuilder { return fakeDelete{c: c} }
+
+func (c *fakeConn) registered() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.nodes)
+}
+
+type fakeCreate struct {
+	curator.CreateBuilder
+	c *fakeConn
+}
+
+func (b fakeCreate) CreatingParentsIfNeeded() curator.CreateBuilder    { return b }
+func (b fakeCreate) WithMode(curator.CreateMode) curator.CreateBuilder { return b }
+
+func (b fakeCreate) ForPathWithData(path string, data []byte) (string, error) {
+	b.c.Lock()
+	defer b.c.Unlock()
+	b.c.nodes[path] = true
+	return path, nil
+}
+
+type fakeDelete struct {
+	curator.DeleteBuilder
+	c *fakeConn
+}
+
+func (b fakeDelete) ForPath(path string) error {
+	b.c.Lock()
+	defer b.c.Unlock()
+	delete(b.c.nodes, path)
+	return nil
+}
+
+func TestServerDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thriftserver")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	killfile := filepath.Join(dir, "kill")
+
+	conn := &fakeConn{nodes: make(map[string]bool)}
+	s, err := New(Config{
+		Name:             "test",
+		Processor:        pingProcessor{},
+		Discovery:        discovery.NewServiceDiscovery(conn, "/services"),
+		Killfiles:        []string{killfile},
+		KillfileInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	waitFor := func(registered int) {
+		for i := 0; i < 100 && conn.registered() != registered; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(1)
+	assert.Equal(t, 1, conn.registered(), "registered once serving")
+
+	ioutil.WriteFile(killfile, nil, 0644)
+	waitFor(0)
+	assert.Equal(t, 0, conn.registered(), "pausing unregisters")
+	assert.Equal(t, 1, len(s.Discovery.Status().Paused))
+
+	os.Remove(killfile)
+	waitFor(1)
+	assert.Equal(t, 1, conn.registered(), "resuming registers again")
+	assert.Equal(t, 0, len(s.Discovery.Status().Paused))
+}
+
+func TestServerAdminAddrInUse(t *testing.T) {
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer admin.Close()
+
+	s, err := New(Config{Name: "test", Processor: pingProcessor{}, Killfiles: []string{}, AdminAddr: admin.Addr().String()})
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Serve(l), "should fail when adminz can't listen")
+}
+
+func TestNeedsProcessor(t *testing.T) {
+	_, err := New(Config{Name: "test"})
+	assert.NotNil(t, err)
+}
+
+func TestBadGraphite(t *testing.T) {
+	for _, graphite := range []string{"graphite", "graphite:2003/", "/prefix", "graphite:port/prefix"} {
+		_, err := New(Config{Name: "test", Processor: pingProcessor{}, Graphite: graphite})
+		assert.NotNil(t, err, graphite)
+	}
+}
+
+func TestServeNeedsTCP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thriftserver")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := New(Config{Name: "test", Processor: pingProcessor{}, Killfiles: []string{}})
+	assert.Nil(t, err)
+	l, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.NotNil(t, s.Serve(l))
+}
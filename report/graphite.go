@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"strconv"
 	"strings"
@@ -33,77 +32,90 @@ func (r *Recorder) sendToGraphite() error {
 	return nil
 }
 
+// Timers that can be cleared once exported, like ClearableTimer.
+type clearable interface {
+	Clear()
+}
+
 func writeStats(r *Recorder, w io.Writer, forHttp bool) {
 	now := ""
 	if !forHttp {
 		now = fmt.Sprintf("%d", time.Now().Unix())
 	}
 
-	du := float64(r.DurationUnit)
 	r.Each(func(name string, i interface{}) {
-		switch metric := i.(type) {
-		case metrics.Counter:
-			if metric.Count() > 0 {
-				fmt.Fprintf(w, r.Format.Counter, r.Prefix, name, metric.Count(), now)
-				if !forHttp {
-					metric.Clear()
-				}
+		if metric, ok := i.(windowed); ok {
+			// nothing to clear: each window is already just its own interval.
+			for _, d := range metric.windowLengths() {
+				writeMetric(r, w, name+"."+windowLabel(d), metric.window(d), now, false)
 			}
-		case metrics.Gauge:
-			fmt.Fprintf(w, r.Format.Gauge, r.Prefix, name, metric.Value(), now)
-		case metrics.GaugeFloat64:
-			fmt.Fprintf(w, r.Format.GaugeFloat64, r.Prefix, name, metric.Value(), now)
-		case metrics.Histogram:
-			h := metric.Snapshot()
-			if h.Count() > 0 {
-				if !forHttp {
-					metric.Clear()
-				}
-				ps := h.Percentiles(r.Percentiles)
-				fmt.Fprintf(w, r.Format.HistogramCount, r.Prefix, name, h.Count(), now)
-				fmt.Fprintf(w, r.Format.Min, r.Prefix, name, h.Min(), now)
-				fmt.Fprintf(w, r.Format.Max, r.Prefix, name, h.Max(), now)
-				fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, h.Mean(), now)
-				fmt.Fprintf(w, r.Format.Stddev, r.Prefix, name, h.StdDev(), now)
-				for psIdx, psKey := range r.Percentiles {
-					key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
-					fmt.Fprintf(w, r.Format.Percentile, r.Prefix, name, key, ps[psIdx], now)
-				}
+			return
+		}
+		writeMetric(r, w, name, i, now, !forHttp)
+	})
+}
+
+// Writes one metric, clearing it once written if reset is set.
+func writeMetric(r *Recorder, w io.Writer, name string, i interface{}, now string, reset bool) {
+	du := float64(r.DurationUnit)
+	switch metric := i.(type) {
+	case metrics.Counter:
+		if metric.Count() > 0 {
+			fmt.Fprintf(w, r.Format.Counter, r.Prefix, name, metric.Count(), now)
+			if reset {
+				metric.Clear()
 			}
-		case metrics.Meter:
-			m := metric.Snapshot()
-			if m.Count() > 0 {
-				fmt.Fprintf(w, r.Format.HistogramCount, r.Prefix, name, m.Count(), now)
-				fmt.Fprintf(w, r.Format.Rate1, r.Prefix, name, m.Rate1(), now)
-				fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, m.RateMean(), now)
+		}
+	case metrics.Gauge:
+		fmt.Fprintf(w, r.Format.Gauge, r.Prefix, name, metric.Value(), now)
+	case metrics.GaugeFloat64:
+		fmt.Fprintf(w, r.Format.GaugeFloat64, r.Prefix, name, metric.Value(), now)
+	case metrics.Histogram:
+		h := metric.Snapshot()
+		if h.Count() > 0 {
+			if reset {
+				metric.Clear()
 			}
-		case metrics.Timer:
-			t := metric.Snapshot()
-			if t.Count() > 0 {
-				if !forHttp {
-					switch timer := metric.(type) {
-					case *ClearableTimer:
-						timer.Clear()
-					default:
-					}
-				}
-				ps := t.Percentiles(r.Percentiles)
-				fmt.Fprintf(w, r.Format.HistogramCount, r.Prefix, name, t.Count(), now)
-				fmt.Fprintf(w, r.Format.Min, r.Prefix, name, t.Min()/int64(du), now)
-				fmt.Fprintf(w, r.Format.Max, r.Prefix, name, t.Max()/int64(du), now)
-				fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, t.Mean()/du, now)
-				fmt.Fprintf(w, r.Format.Stddev, r.Prefix, name, t.StdDev()/du, now)
-				for psIdx, psKey := range r.Percentiles {
-					key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
-					fmt.Fprintf(w, r.Format.Percentile, r.Prefix, name, key, ps[psIdx]/du, now)
-				}
-				fmt.Fprintf(w, r.Format.Rate1, r.Prefix, name, t.Rate1(), now)
-				fmt.Fprintf(w, r.Format.Rate5, r.Prefix, name, t.Rate5(), now)
-				fmt.Fprintf(w, r.Format.Rate15, r.Prefix, name, t.Rate15(), now)
-				fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, t.RateMean(), now)
+			ps := h.Percentiles(r.Percentiles)
+			fmt.Fprintf(w, r.Format.HistogramCount, r.Prefix, name, h.Count(), now)
+			fmt.Fprintf(w, r.Format.Min, r.Prefix, name, h.Min(), now)
+			fmt.Fprintf(w, r.Format.Max, r.Prefix, name, h.Max(), now)
+			fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, h.Mean(), now)
+			fmt.Fprintf(w, r.Format.Stddev, r.Prefix, name, h.StdDev(), now)
+			for psIdx, psKey := range r.Percentiles {
+				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
+				fmt.Fprintf(w, r.Format.Percentile, r.Prefix, name, key, ps[psIdx], now)
 			}
-		default:
-			log.Printf("Cannot export unknown metric type %T for '%s'\n", i, name)
 		}
-	})
+	case metrics.Meter:
+		m := metric.Snapshot()
+		if m.Count() > 0 {
+			fmt.Fprintf(w, r.Format.HistogramCount, r.Prefix, name, m.Count(), now)
+			fmt.Fprintf(w, r.Format.Rate1, r.Prefix, name, m.Rate1(), now)
+			fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, m.RateMean(), now)
+		}
+	case metrics.Timer:
+		t := metric.Snapshot()
+		if t.Count() > 0 {
+			if timer, ok := metric.(clearable); ok && reset {
+				timer.Clear()
+			}
+			ps := t.Percentiles(r.Percentiles)
+			fmt.Fprintf(w, r.Format.HistogramCount, r.Prefix, name, t.Count(), now)
+			fmt.Fprintf(w, r.Format.Min, r.Prefix, name, t.Min()/int64(du), now)
+			fmt.Fprintf(w, r.Format.Max, r.Prefix, name, t.Max()/int64(du), now)
+			fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, t.Mean()/du, now)
+			fmt.Fprintf(w, r.Format.Stddev, r.Prefix, name, t.StdDev()/du, now)
+			for psIdx, psKey := range r.Percentiles {
+				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
+				fmt.Fprintf(w, r.Format.Percentile, r.Prefix, name, key, ps[psIdx]/du, now)
+			}
+			fmt.Fprintf(w, r.Format.Rate1, r.Prefix, name, t.Rate1(), now)
+			fmt.Fprintf(w, r.Format.Rate5, r.Prefix, name, t.Rate5(), now)
+			fmt.Fprintf(w, r.Format.Rate15, r.Prefix, name, t.Rate15(), now)
+			fmt.Fprintf(w, r.Format.Mean, r.Prefix, name, t.RateMean(), now)
+		}
+	default:
+		logger.Warn("cannot export unknown metric type", "type", fmt.Sprintf("%T", i), "metric", name)
+	}
 }
@@ -0,0 +1,166 @@
+package report
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// A Backend makes the sample behind a new histogram or timer, which decides how much memory it
+// uses and how its percentiles are computed. Whichever backend a metric uses, it is exported the
+// same way, to graphite and over HTTP.
+type Backend func() metrics.Sample
+
+// Uniform keeps a uniformly random sample of up to size values, so percentiles are exact until
+// size values have been seen. This is the default for histograms, with a size of 30,000.
+func Uniform(size int) Backend {
+	return func() metrics.Sample { return metrics.NewUniformSample(size) }
+}
+
+// ExpDecay keeps a sample of up to size values biased towards recent ones, by alpha, so percentiles
+// follow recent behavior, as go-metrics' own timers do with ExpDecay(1028, 0.015).
+func ExpDecay(size int, alpha float64) Backend {
+	return func() metrics.Sample { return metrics.NewExpDecaySample(size, alpha) }
+}
+
+// HDR counts values from lowest to highest in buckets that keep sigfigs significant figures
+// (1 to 5), so percentiles are within a relative error of 10^-sigfigs however many values are
+// seen, in a fixed amount of memory. Values below lowest aren't distinguished from each other, and
+// values above highest are counted as highest. For timers, values are in nanoseconds, eg
+// HDR(int64(time.Microsecond), int64(time.Minute), 2).
+func HDR(lowest, highest int64, sigfigs int) Backend {
+	b := newHDRBuckets(lowest, highest, sigfigs)
+	return func() metrics.Sample { return newBucketSample(b) }
+}
+
+// Buckets counts values in fixed buckets, each up to and including one of bounds, plus one for
+// values above them all. A percentile is reported as the bound of the bucket it falls in, or the
+// largest value seen if that's smaller. For timers, bounds are in nanoseconds.
+func Buckets(bounds ...int64) Backend {
+	b := make(fixedBuckets, len(bounds))
+	copy(b, bounds)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return func() metrics.Sample { return newBucketSample(b) }
+}
+
+// DurationBuckets is Buckets, for timers, with bounds given as durations.
+func DurationBuckets(bounds ...time.Duration) Backend {
+	b := make([]int64, len(bounds))
+	for i, d := range bounds {
+		b[i] = int64(d)
+	}
+	return Buckets(b...)
+}
+
+// HistogramsFor sets the backend for histograms and timers named name, or with names starting
+// with prefix if name is "prefix*", overriding Histograms. The longest match wins. It only affects
+// metrics not yet created.
+func (r *Recorder) HistogramsFor(name string, backend Backend) *Recorder {
+	r.backendsLock.Lock()
+	defer r.backendsLock.Unlock()
+	if r.backends == nil {
+		r.backends = make(map[string]Backend)
+	}
+	r.backends[name] = backend
+	return r
+}
+
+// The backend configured for the metric named name, if any.
+func (r *Recorder) backendFor(name string) Backend {
+	r.backendsLock.Lock()
+	defer r.backendsLock.Unlock()
+	if b, ok := r.backends[name]; ok {
+		return b
+	}
+	var match Backend
+	longest := -1
+	for pattern, b := range r.backends {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern && strings.HasPrefix(name, prefix) && len(prefix) > longest {
+			match, longest = b, len(prefix)
+		}
+	}
+	if match != nil {
+		return match
+	}
+	return r.Histograms
+}
+
+// The backend for a windowed metric named name, which defaults to windowedBackend.
+func (r *Recorder) windowedBackendFor(name string) Backend {
+	if b := r.backendFor(name); b != nil {
+		return b
+	}
+	return windowedBackend
+}
+
+// A metrics.Histogram on any Sample. metrics.NewHistogram only snapshots go-metrics' own samples.
+type histogram struct {
+	sample metrics.Sample
+}
+
+func newHistogram(s metrics.Sample) *histogram {
+	return &histogram{s}
+}
+
+func (h *histogram) Clear()                             { h.sample.Clear() }
+func (h *histogram) Count() int64                       { return h.sample.Count() }
+func (h *histogram) Max() int64                         { return h.sample.Max() }
+func (h *histogram) Mean() float64                      { return h.sample.Mean() }
+func (h *histogram) Min() int64                         { return h.sample.Min() }
+func (h *histogram) Percentile(p float64) float64       { return h.sample.Percentile(p) }
+func (h *histogram) Percentiles(ps []float64) []float64 { return h.sample.Percentiles(ps) }
+func (h *histogram) Sample() metrics.Sample             { return h.sample }
+func (h *histogram) Snapshot() metrics.Histogram        { return &histogram{h.sample.Snapshot()} }
+func (h *histogram) StdDev() float64                    { return h.sample.StdDev() }
+func (h *histogram) Sum() int64                         { return h.sample.Sum() }
+func (h *histogram) Update(v int64)                     { h.sample.Update(v) }
+func (h *histogram) Variance() float64                  { return h.sample.Variance() }
+
+// A metrics.Timer on a histogram of any Sample, which, unlike go-metrics' own timers, can be
+// cleared once exported to graphite.
+type timer struct {
+	h metrics.Histogram
+	m metrics.Meter
+}
+
+func newTimer(s metrics.Sample) *timer {
+	return &timer{newHistogram(s), metrics.NewMeter()}
+}
+
+// Clear resets the timer's histogram, but not its rates.
+func (t *timer) Clear() {
+	t.h.Clear()
+}
+
+func (t *timer) Count() int64                       { return t.h.Count() }
+func (t *timer) Max() int64                         { return t.h.Max() }
+func (t *timer) Mean() float64                      { return t.h.Mean() }
+func (t *timer) Min() int64                         { return t.h.Min() }
+func (t *timer) Percentile(p float64) float64       { return t.h.Percentile(p) }
+func (t *timer) Percentiles(ps []float64) []float64 { return t.h.Percentiles(ps) }
+func (t *timer) Rate1() float64                     { return t.m.Rate1() }
+func (t *timer) Rate5() float64                     { return t.m.Rate5() }
+func (t *timer) Rate15() float64                    { return t.m.Rate15() }
+func (t *timer) RateMean() float64                  { return t.m.RateMean() }
+func (t *timer) Snapshot() metrics.Timer            { return &timer{t.h.Snapshot(), t.m.Snapshot()} }
+func (t *timer) StdDev() float64                    { return t.h.StdDev() }
+func (t *timer) Stop()                              { t.m.Stop() }
+func (t *timer) Sum() int64                         { return t.h.Sum() }
+func (t *timer) Variance() float64                  { return t.h.Variance() }
+
+func (t *timer) Time(f func()) {
+	start := time.Now()
+	f()
+	t.UpdateSince(start)
+}
+
+func (t *timer) Update(d time.Duration) {
+	t.h.Update(int64(d))
+	t.m.Mark(1)
+}
+
+func (t *timer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
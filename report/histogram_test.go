@@ -0,0 +1,153 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHDRBuckets(t *testing.T) {
+	for _, sigfigs := range []int{1, 2, 3} {
+		h := newHDRBuckets(1, 1<<40, sigfigs)
+		maxErr := math.Pow10(-sigfigs)
+		prev := 0
+		for v := int64(1); v < 1<<40; v += 1 + v/997 {
+			i := h.index(v)
+			assert.True(t, i >= prev, "buckets out of order at %d", v)
+			prev = i
+			got := h.value(i)
+			assert.True(t, math.Abs(float64(got-v))/float64(v) <= maxErr, "%d sigfigs: %d reported as %d", sigfigs, v, got)
+		}
+		assert.Equal(t, h.index(1<<40), h.index(1<<50), "clamped to highest")
+	}
+
+	coarse := newHDRBuckets(1000, 1000000, 2)
+	assert.Equal(t, coarse.index(0), coarse.index(1000/2), "below lowest")
+}
+
+func TestBucketSamples(t *testing.T) {
+	hdr := HDR(1, 1000000, 2)()
+	for v := int64(1); v <= 100000; v++ {
+		hdr.Update(v)
+	}
+	assert.Equal(t, int64(100000), hdr.Count())
+	assert.Equal(t, int64(1), hdr.Min())
+	assert.Equal(t, int64(100000), hdr.Max())
+	assert.InDelta(t, 50000.5, hdr.Mean(), 0.001)
+	assert.InDelta(t, 28867.5, hdr.StdDev(), 1)
+	for i, p := range hdr.Percentiles([]float64{0.99, 0.5, 0.999}) {
+		expected := []float64{99000, 50000, 99900}[i]
+		assert.InEpsilon(t, expected, p, 0.01)
+	}
+
+	buckets := Buckets(100, 10, 1000)()
+	for _, v := range []int64{1, 5, 10, 50, 200, 5000} {
+		buckets.Update(v)
+	}
+	assert.Equal(t, []float64{10, 10, 100, 1000, 5000}, buckets.Percentiles([]float64{0.1, 0.5, 0.6, 0.8, 1}))
+	assert.Equal(t, []int64{10, 10, 10, 100, 1000, 5000}, buckets.Values())
+
+	snapshot := buckets.Snapshot()
+	buckets.Clear()
+	assert.Equal(t, int64(0), buckets.Count())
+	assert.Equal(t, []float64{0}, buckets.Percentiles([]float64{0.5}))
+	assert.Equal(t, int64(6), snapshot.Count())
+}
+
+func TestHistogramsFor(t *testing.T) {
+	r := NewRecorder()
+	r.Histograms = ExpDecay(100, 0.015)
+	r.HistogramsFor("rpc.*", HDR(1, int64(time.Minute), 2))
+	r.HistogramsFor("rpc.slow.*", DurationBuckets(time.Second, time.Minute))
+	r.HistogramsFor("rpc.fast", Uniform(10))
+
+	sample := func(name string) metrics.Sample {
+		return r.GetTimer(name).(*timer).h.Sample()
+	}
+	assert.IsType(t, &metrics.ExpDecaySample{}, sample("other"))
+	assert.IsType(t, &metrics.UniformSample{}, sample("rpc.fast"))
+	assert.IsType(t, &bucketSample{}, sample("rpc.get"))
+	assert.IsType(t, fixedBuckets{}, sample("rpc.slow.get").(*bucketSample).buckets)
+	assert.IsType(t, &metrics.ExpDecaySample{}, r.GetHistogram("size").Sample())
+
+	// without a backend, metrics are made as they always have been.
+	r = NewRecorder()
+	assert.IsType(t, &metrics.UniformSample{}, r.GetHistogram("size").Sample())
+	assert.IsType(t, &metrics.StandardTimer{}, r.GetTimer("rpc.get"))
+}
+
+// Each backend is exported with the same keys, in the text and JSON served over HTTP as well as to
+// graphite, and cleared once sent to graphite.
+func TestBackendExports(t *testing.T) {
+	backends := map[string]Backend{
+		"uniform":  Uniform(100),
+		"expdecay": ExpDecay(100, 0.015),
+		"hdr":      HDR(int64(time.Microsecond), int64(time.Minute), 3),
+		"buckets":  DurationBuckets(time.Second, 2*time.Second, 5*time.Second),
+	}
+	var expectedKeys []string
+	for name, backend := range backends {
+		r := NewRecorder()
+		r.Prefix = "foobar"
+		r.Histograms = backend
+		fillMetrics(r)
+		for _, v := range []int64{1, 2, 3} {
+			r.GetHistogram("size").Update(v)
+		}
+
+		var buf bytes.Buffer
+		writeStats(r, &buf, true)
+		stats := parseStats(buf.String())
+		assert.Equal(t, 5.0, stats["foobar.baz.count"], name)
+		assert.Equal(t, 1000.0, stats["foobar.baz.min"], name)
+		assert.Equal(t, 5000.0, stats["foobar.baz.max"], name)
+		assert.Equal(t, 5000.0, stats["foobar.baz.percentiles.p99"], name)
+		assert.Equal(t, 3.0, stats["foobar.size.max"], name)
+		keys := sortedKeys(stats)
+		if expectedKeys == nil {
+			expectedKeys = keys
+		}
+		assert.Equal(t, expectedKeys, keys, name)
+
+		buf.Reset()
+		metrics.WriteJSONOnce(r, &buf)
+		var js struct{ Registry map[string]map[string]float64 }
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &js), name)
+		assert.Equal(t, 5.0, js.Registry["baz"]["count"], name)
+		assert.Equal(t, 3000.0, js.Registry["baz"]["mean"]/1e6, name)
+		assert.Equal(t, float64(5*time.Second), js.Registry["baz"]["max"], name)
+
+		buf.Reset()
+		writeStats(r, &buf, false)
+		assert.Equal(t, 5.0, parseStats(buf.String())["foobar.baz.count"], name)
+		assert.Equal(t, int64(0), r.GetTimer("baz").Count(), name)
+		assert.Equal(t, int64(0), r.GetHistogram("size").Count(), name)
+	}
+}
+
+func parseStats(s string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		var v float64
+		parts := strings.Fields(line)
+		json.Unmarshal([]byte(parts[1]), &v)
+		res[parts[0]] = v
+	}
+	return res
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var res []string
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
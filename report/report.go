@@ -1,14 +1,20 @@
 package report
 
 import (
+	"bytes"
 	"flag"
+	"html/template"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/foursquare/fsgo/adminz"
+	"github.com/foursquare/fsgo/logging"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -32,25 +38,29 @@ func Flag() *string {
 	return flag.String("graphite", "", "graphite server/prefix for reporting collected metrics")
 }
 
+var logger = logging.New("report")
+
 type Recorder struct {
 	metrics.Registry
 	Format        ExportFormatStrings
 	DurationUnit  time.Duration // Time conversion unit for durations
 	Prefix        string        // Prefix to be prepended to metric names
 	Percentiles   []float64     // Percentiles to export from timers and histograms
+	Histograms    Backend       `json:"-"` // Backs new histograms and timers, unless set by HistogramsFor
 	flushInterval time.Duration
 	graphite      *net.TCPAddr
+	backends      map[string]Backend
+	backendsLock  sync.Mutex
+	windows       *windowing
 }
 
 func NewRecorder() *Recorder {
 	return &Recorder{
-		metrics.NewRegistry(),
-		OstrichFormats,
-		time.Millisecond,
-		"",
-		[]float64{0.5, 0.9, 0.95, 0.99, 0.999},
-		time.Minute,
-		nil,
+		Registry:      metrics.NewRegistry(),
+		Format:        OstrichFormats,
+		DurationUnit:  time.Millisecond,
+		Percentiles:   []float64{0.5, 0.9, 0.95, 0.99, 0.999},
+		flushInterval: time.Minute,
 	}
 }
 
@@ -76,9 +86,17 @@ func (c *ClearableTimer) Clear() {
 	c.h.Clear()
 }
 
-func (r *Recorder) makeTimer() metrics.Timer {
+// Makes a timer on the backend for name. Without one, timers use go-metrics' default, or, when
+// reporting to graphite, a uniform sample that is cleared after each export.
+func (r *Recorder) makeTimer(name string) metrics.Timer {
+	if r.windows != nil {
+		return newWindowedTimer(r.windows, r.windowedBackendFor(name))
+	}
+	if b := r.backendFor(name); b != nil {
+		return newTimer(b())
+	}
 	if r.graphite != nil {
-		h := r.makeHistogram()
+		h := r.makeHistogram(name)
 		t := metrics.NewCustomTimer(h, metrics.NewMeter())
 		return &ClearableTimer{t, h}
 	} else {
@@ -86,20 +104,33 @@ func (r *Recorder) makeTimer() metrics.Timer {
 	}
 }
 
-func (r *Recorder) makeHistogram() metrics.Histogram {
+func (r *Recorder) makeHistogram(name string) metrics.Histogram {
+	if r.windows != nil {
+		return newWindowedHistogram(r.windows, r.windowedBackendFor(name))
+	}
+	if b := r.backendFor(name); b != nil {
+		return newHistogram(b())
+	}
 	return metrics.NewHistogram(metrics.NewUniformSample(1000 * 30))
 }
 
 func (r *Recorder) GetHistogram(name string) Histogram {
-	return r.GetOrRegister(name, r.makeHistogram).(Histogram)
+	return r.GetOrRegister(name, func() metrics.Histogram { return r.makeHistogram(name) }).(Histogram)
 }
 
 func (r *Recorder) GetTimer(name string) Timer {
-	return r.GetOrRegister(name, r.makeTimer).(Timer)
+	return r.GetOrRegister(name, func() metrics.Timer { return r.makeTimer(name) }).(Timer)
+}
+
+func (r *Recorder) makeMeter() metrics.Meter {
+	if r.windows != nil {
+		return newWindowedMeter(r.windows)
+	}
+	return metrics.NewMeter()
 }
 
 func (r *Recorder) GetMeter(name string) Meter {
-	return metrics.GetOrRegisterMeter(name, r)
+	return r.GetOrRegister(name, r.makeMeter).(Meter)
 }
 
 func (r *Recorder) Inc(name string) {
@@ -115,7 +146,7 @@ func (r *Recorder) TimeSince(name string, t time.Time) {
 }
 
 func (r *Recorder) LogToConsole(freq time.Duration) *Recorder {
-	log.Println("Stats reporting enabled...")
+	logger.Info("stats reporting to console enabled", "every", freq)
 	go metrics.LogScaled(r, freq, time.Millisecond, log.New(os.Stderr, "metrics: ", log.Lmicroseconds))
 	return r
 }
@@ -144,7 +175,7 @@ func (r *Recorder) ReportTo(serverSlashPrefix string) *Recorder {
 }
 
 func (r *Recorder) ReportToServer(graphiteServer, graphitePrefix string) *Recorder {
-	log.Printf("Stats reporting to graphite server '%s' under '%s'...\n", graphiteServer, graphitePrefix)
+	logger.Info("stats reporting to graphite", "server", graphiteServer, "prefix", graphitePrefix)
 	addr, err := net.ResolveTCPAddr("tcp", graphiteServer)
 	if err != nil {
 		panic(err)
@@ -160,7 +191,7 @@ func (r *Recorder) ReportToServer(graphiteServer, graphitePrefix string) *Record
 func (r *Recorder) FlushNow() {
 	if r.graphite != nil {
 		if err := r.sendToGraphite(); nil != err {
-			log.Println(err)
+			logger.Error("failed to send stats to graphite", "err", err)
 		}
 	}
 }
@@ -185,18 +216,38 @@ func GetDefault() *Recorder {
 }
 
 func Inc(name string) {
-	metrics.GetOrRegisterMeter(name, GetDefault()).Mark(1)
+	GetDefault().Inc(name)
 }
 
 func Time(name string, du time.Duration) {
-	metrics.GetOrRegisterTimer(name, GetDefault()).Update(du)
+	GetDefault().Time(name, du)
 }
 
 func TimeSince(name string, t time.Time) {
-	metrics.GetOrRegisterTimer(name, GetDefault()).UpdateSince(t)
+	GetDefault().TimeSince(name, t)
 }
 
+// Serves current stats as text, in the format sent to graphite, or as JSON or an HTML page when
+// asked for (see adminz.Render).
 func (r *Recorder) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+	if adminz.WantsJSON(req) {
+		out.Header().Add("Content-Type", "application/json")
+		metrics.WriteJSONOnce(r, out)
+		return
+	}
+	if strings.Contains(req.Header.Get("Accept"), "text/html") {
+		var buf bytes.Buffer
+		writeStats(r, &buf, true)
+		adminz.Render(out, req, "statz", nil, func(w io.Writer) {
+			io.WriteString(w, "<pre>"+template.HTMLEscapeString(buf.String())+"</pre>")
+		})
+		return
+	}
 	out.Header().Add("Content-Type", "text/plain")
 	writeStats(r, out, true)
 }
+
+// Describes the page for the adminz index.
+func (r *Recorder) AdminDescription() string {
+	return "Current metrics (as sent to graphite)"
+}
@@ -0,0 +1,313 @@
+package report
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// How a bucketSample maps values to buckets.
+type bucketing interface {
+	// bucket v is counted in
+	index(v int64) int
+	// value reported for values counted in bucket i
+	value(i int) int64
+}
+
+// A metrics.Sample that counts values in buckets rather than keeping them, so its memory is bounded
+// by the buckets, not the number of values it sees. Buckets are only allocated up to the largest
+// value seen. Count, min, max, mean and stddev are exact; percentiles are only as
+// precise as the buckets.
+type bucketSample struct {
+	sync.Mutex
+	buckets bucketing
+	counts  []int64
+	count   int64
+	min     int64
+	max     int64
+	sum     int64
+	sumSq   float64
+}
+
+func newBucketSample(b bucketing) *bucketSample {
+	return &bucketSample{buckets: b}
+}
+
+func (s *bucketSample) Clear() {
+	s.Lock()
+	defer s.Unlock()
+	for i := range s.counts {
+		s.counts[i] = 0
+	}
+	s.count, s.min, s.max, s.sum, s.sumSq = 0, 0, 0, 0, 0
+}
+
+func (s *bucketSample) Update(v int64) {
+	s.Lock()
+	defer s.Unlock()
+	i := s.buckets.index(v)
+	if i >= len(s.counts) {
+		s.counts = append(s.counts, make([]int64, i+1-len(s.counts))...)
+	}
+	s.counts[i]++
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	s.sumSq += float64(v) * float64(v)
+}
+
+func (s *bucketSample) Count() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.count
+}
+
+// Size is the number of values counted, as a bucketSample never drops any.
+func (s *bucketSample) Size() int {
+	return int(s.Count())
+}
+
+func (s *bucketSample) Min() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.min
+}
+
+func (s *bucketSample) Max() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.max
+}
+
+func (s *bucketSample) Sum() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.sum
+}
+
+func (s *bucketSample) Mean() float64 {
+	s.Lock()
+	defer s.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return float64(s.sum) / float64(s.count)
+}
+
+func (s *bucketSample) Variance() float64 {
+	s.Lock()
+	defer s.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	mean := float64(s.sum) / float64(s.count)
+	return math.Max(s.sumSq/float64(s.count)-mean*mean, 0)
+}
+
+func (s *bucketSample) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+func (s *bucketSample) Percentile(p float64) float64 {
+	return s.Percentiles([]float64{p})[0]
+}
+
+// Percentiles are the values reported for the buckets the percentiles fall in, kept within the
+// min and max seen.
+func (s *bucketSample) Percentiles(ps []float64) []float64 {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]float64, len(ps))
+	if s.count == 0 {
+		return res
+	}
+	order := make([]int, len(ps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return ps[order[i]] < ps[order[j]] })
+
+	var seen int64
+	b := 0
+	for _, i := range order {
+		rank := int64(math.Ceil(ps[i] * float64(s.count)))
+		if rank < 1 {
+			rank = 1
+		}
+		for ; b < len(s.counts); b++ {
+			if seen+s.counts[b] >= rank {
+				break
+			}
+			seen += s.counts[b]
+		}
+		res[i] = float64(s.clamp(s.buckets.value(b)))
+	}
+	return res
+}
+
+func (s *bucketSample) clamp(v int64) int64 {
+	if v < s.min {
+		return s.min
+	}
+	if v > s.max {
+		return s.max
+	}
+	return v
+}
+
+// Values approximates the values seen by the values reported for their buckets.
+func (s *bucketSample) Values() []int64 {
+	s.Lock()
+	defer s.Unlock()
+	res := make([]int64, 0, s.count)
+	for b, n := range s.counts {
+		v := s.clamp(s.buckets.value(b))
+		for ; n > 0; n-- {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// Snapshot is a copy of the sample, which isn't changed by later updates.
+func (s *bucketSample) Snapshot() metrics.Sample {
+	s.Lock()
+	defer s.Unlock()
+	c := &bucketSample{
+		buckets: s.buckets,
+		counts:  make([]int64, len(s.counts)),
+		count:   s.count,
+		min:     s.min,
+		max:     s.max,
+		sum:     s.sum,
+		sumSq:   s.sumSq,
+	}
+	copy(c.counts, s.counts)
+	return c
+}
+
+// Merges samples from the same backend, eg for consecutive intervals, into one. Samples that count
+// in buckets are merged exactly; others are merged by pooling the values they kept.
+func mergeSamples(samples []metrics.Sample) metrics.Sample {
+	var merged *bucketSample
+	for _, s := range samples {
+		b, ok := s.(*bucketSample)
+		if !ok {
+			merged = nil
+			break
+		}
+		b.Lock()
+		if merged == nil {
+			merged = &bucketSample{buckets: b.buckets}
+		}
+		if b.count > 0 {
+			if merged.count == 0 || b.min < merged.min {
+				merged.min = b.min
+			}
+			if merged.count == 0 || b.max > merged.max {
+				merged.max = b.max
+			}
+			if len(b.counts) > len(merged.counts) {
+				merged.counts = append(merged.counts, make([]int64, len(b.counts)-len(merged.counts))...)
+			}
+			for i, n := range b.counts {
+				merged.counts[i] += n
+			}
+			merged.count += b.count
+			merged.sum += b.sum
+			merged.sumSq += b.sumSq
+		}
+		b.Unlock()
+	}
+	if merged != nil {
+		return merged
+	}
+
+	var count int64
+	var values []int64
+	for _, s := range samples {
+		count += s.Count()
+		values = append(values, s.Values()...)
+	}
+	return metrics.NewSampleSnapshot(count, values)
+}
+
+// Buckets in the style of an HDR histogram: values are counted exactly up to subBuckets, then
+// each doubling of the range is split into subBuckets/2 equal buckets, so every bucket is within
+// a fixed relative error of the values in it. Values are first shifted right by unit, so those
+// smaller than 1<<unit aren't distinguished, and clamped to highest.
+type hdrBuckets struct {
+	unit    uint
+	subBits uint // subBuckets is 1<<subBits
+	highest int64
+}
+
+func newHDRBuckets(lowest, highest int64, sigfigs int) *hdrBuckets {
+	if sigfigs < 1 || sigfigs > 5 {
+		panic("report: HDR histograms need 1 to 5 significant figures")
+	}
+	if lowest < 1 {
+		lowest = 1
+	}
+	if highest < lowest {
+		panic("report: HDR histogram's highest value must be at least its lowest")
+	}
+	h := &hdrBuckets{highest: highest}
+	// the largest power of two no bigger than lowest.
+	h.unit = uint(bits.Len64(uint64(lowest)) - 1)
+	// enough sub-buckets that reporting a bucket's middle is within 10^-sigfigs of its values.
+	h.subBits = uint(bits.Len64(uint64(math.Pow10(sigfigs)) - 1))
+	return h
+}
+
+func (h *hdrBuckets) index(v int64) int {
+	if v < 0 {
+		v = 0
+	}
+	if v > h.highest {
+		v = h.highest
+	}
+	u := uint64(v) >> h.unit
+	if u < 1<<h.subBits {
+		return int(u)
+	}
+	shift := uint(bits.Len64(u)) - h.subBits
+	half := uint64(1) << (h.subBits - 1)
+	return int(1<<h.subBits + uint64(shift-1)*half + (u>>shift - half))
+}
+
+// the middle of the range counted in bucket i.
+func (h *hdrBuckets) value(i int) int64 {
+	subBuckets := 1 << h.subBits
+	if i < subBuckets {
+		return int64(i)<<h.unit + (int64(1)<<h.unit-1)/2
+	}
+	half := subBuckets / 2
+	shift := uint((i-subBuckets)/half + 1)
+	low := int64(half+(i-subBuckets)%half) << (shift + h.unit)
+	width := int64(1) << (shift + h.unit)
+	return low + (width-1)/2
+}
+
+// Buckets with fixed inclusive upper bounds, plus one for values above the last.
+type fixedBuckets []int64
+
+func (f fixedBuckets) index(v int64) int {
+	return sort.Search(len(f), func(i int) bool { return v <= f[i] })
+}
+
+// the upper bound of bucket i. The bucket above the last bound reports the max seen, via clamp.
+func (f fixedBuckets) value(i int) int64 {
+	if i == len(f) {
+		return math.MaxInt64
+	}
+	return f[i]
+}
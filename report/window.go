@@ -0,0 +1,347 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Mocked out in tests.
+var clock = time.Now
+
+// Backs windowed histograms and timers without a backend of their own: 2 significant figures,
+// over any positive value, in memory that only grows with the largest value seen.
+var windowedBackend = HDR(1, math.MaxInt64, 2)
+
+// Windows makes the histograms, timers and meters the Recorder creates from now on windowed: they
+// count in sub-buckets as long as the shortest window, rotated as time passes, and report each
+// window, eg 1m, 5m and 15m, over the last completed sub-buckets, as "<name>.1m" and so on. So a
+// 1m window shows the last full minute, the same on /statz as sent to graphite, and neither
+// reading nor exporting clears anything. Windows are rounded up to a multiple of the shortest.
+//
+// Windowed histograms and timers merge their sub-buckets, so are best backed by HDR or Buckets,
+// which merge exactly, and default to HDR with 2 significant figures. Meters' rates are
+// per-second averages over each window. A windowed metric's own methods, and JSON, report its
+// shortest window. Windows that aren't positive are logged and ignored.
+func (r *Recorder) Windows(windows ...time.Duration) *Recorder {
+	var valid []time.Duration
+	for _, d := range windows {
+		if d <= 0 {
+			logger.Error("ignoring window that isn't positive", "window", d)
+			continue
+		}
+		valid = append(valid, d)
+	}
+	if len(valid) == 0 {
+		r.windows = nil
+		return r
+	}
+	w := &windowing{}
+	for _, d := range valid {
+		if w.tick == 0 || d < w.tick {
+			w.tick = d
+		}
+	}
+	seen := make(map[time.Duration]bool)
+	for _, d := range valid {
+		d = (d + w.tick - 1) / w.tick * w.tick
+		if !seen[d] {
+			w.lengths = append(w.lengths, d)
+			seen[d] = true
+		}
+	}
+	sort.Slice(w.lengths, func(i, j int) bool { return w.lengths[i] < w.lengths[j] })
+	r.windows = w
+	return r
+}
+
+// How windowed metrics are split up: into sub-buckets of tick, aligned to the clock so every
+// metric rotates at once, reported over windows of lengths.
+type windowing struct {
+	tick    time.Duration
+	lengths []time.Duration
+}
+
+// Labels a window, eg "1m" or "90s".
+func windowLabel(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+	}
+}
+
+// A windowed metric, exported as one metric per window.
+type windowed interface {
+	windowLengths() []time.Duration
+	// a snapshot of the window of length d: a metrics.Histogram, Timer or Meter.
+	window(d time.Duration) interface{}
+}
+
+// Tracks which of a windowed metric's sub-buckets is current. Not safe for concurrent use.
+type ring struct {
+	*windowing
+	size    int
+	cur     int
+	epoch   int64 // tick the current sub-bucket is for
+	created time.Time
+}
+
+func newRing(w *windowing) ring {
+	now := clock()
+	return ring{
+		windowing: w,
+		size:      int(w.lengths[len(w.lengths)-1]/w.tick) + 1,
+		epoch:     now.UnixNano() / int64(w.tick),
+		created:   now,
+	}
+}
+
+func (r *ring) windowLengths() []time.Duration {
+	return r.lengths
+}
+
+// Moves on to the sub-bucket for now, calling reset on each sub-bucket reused along the way.
+func (r *ring) rotate(reset func(i int)) {
+	epoch := clock().UnixNano() / int64(r.tick)
+	steps := epoch - r.epoch
+	if steps <= 0 {
+		return
+	}
+	if steps > int64(r.size) {
+		steps = int64(r.size)
+	}
+	for ; steps > 0; steps-- {
+		r.cur = (r.cur + 1) % r.size
+		reset(r.cur)
+	}
+	r.epoch = epoch
+}
+
+// The completed sub-buckets in the window of length d, and how many seconds of it the metric
+// existed for.
+func (r *ring) span(d time.Duration) ([]int, float64) {
+	n := int(d / r.tick)
+	slots := make([]int, n)
+	for i := range slots {
+		slots[i] = (r.cur - 1 - i + r.size) % r.size
+	}
+	end := time.Unix(0, r.epoch*int64(r.tick))
+	start := end.Add(-d)
+	if r.created.After(start) {
+		start = r.created
+	}
+	return slots, math.Max(end.Sub(start).Seconds(), 0)
+}
+
+// A metrics.Histogram over sliding windows.
+type windowedHistogram struct {
+	sync.Mutex
+	ring
+	samples []metrics.Sample
+}
+
+func newWindowedHistogram(w *windowing, backend Backend) *windowedHistogram {
+	h := &windowedHistogram{ring: newRing(w)}
+	h.samples = make([]metrics.Sample, h.size)
+	for i := range h.samples {
+		h.samples[i] = backend()
+	}
+	return h
+}
+
+func (h *windowedHistogram) reset(i int) {
+	h.samples[i].Clear()
+}
+
+func (h *windowedHistogram) Update(v int64) {
+	h.Lock()
+	defer h.Unlock()
+	h.rotate(h.reset)
+	h.samples[h.cur].Update(v)
+}
+
+// Merges the window of length d, returning how many seconds it covers.
+func (h *windowedHistogram) merged(d time.Duration) (metrics.Histogram, float64) {
+	h.Lock()
+	defer h.Unlock()
+	h.rotate(h.reset)
+	slots, secs := h.span(d)
+	samples := make([]metrics.Sample, len(slots))
+	for i, slot := range slots {
+		samples[i] = h.samples[slot]
+	}
+	return &histogram{mergeSamples(samples)}, secs
+}
+
+func (h *windowedHistogram) window(d time.Duration) interface{} {
+	merged, _ := h.merged(d)
+	return merged
+}
+
+// The shortest window.
+func (h *windowedHistogram) view() metrics.Histogram {
+	merged, _ := h.merged(h.lengths[0])
+	return merged
+}
+
+// Clear empties every sub-bucket.
+func (h *windowedHistogram) Clear() {
+	h.Lock()
+	defer h.Unlock()
+	for i := range h.samples {
+		h.reset(i)
+	}
+}
+
+func (h *windowedHistogram) Count() int64                       { return h.view().Count() }
+func (h *windowedHistogram) Max() int64                         { return h.view().Max() }
+func (h *windowedHistogram) Mean() float64                      { return h.view().Mean() }
+func (h *windowedHistogram) Min() int64                         { return h.view().Min() }
+func (h *windowedHistogram) Percentile(p float64) float64       { return h.view().Percentile(p) }
+func (h *windowedHistogram) Percentiles(ps []float64) []float64 { return h.view().Percentiles(ps) }
+func (h *windowedHistogram) Sample() metrics.Sample             { return h.view().Sample() }
+func (h *windowedHistogram) Snapshot() metrics.Histogram        { return h.view() }
+func (h *windowedHistogram) StdDev() float64                    { return h.view().StdDev() }
+func (h *windowedHistogram) Sum() int64                         { return h.view().Sum() }
+func (h *windowedHistogram) Variance() float64                  { return h.view().Variance() }
+
+// A metrics.Timer over sliding windows. Its rates come from the count in each window.
+type windowedTimer struct {
+	h *windowedHistogram
+}
+
+func newWindowedTimer(w *windowing, backend Backend) *windowedTimer {
+	return &windowedTimer{newWindowedHistogram(w, backend)}
+}
+
+func (t *windowedTimer) windowLengths() []time.Duration {
+	return t.h.lengths
+}
+
+func (t *windowedTimer) window(d time.Duration) interface{} {
+	return t.snapshot(d)
+}
+
+func (t *windowedTimer) snapshot(d time.Duration) metrics.Timer {
+	h, secs := t.h.merged(d)
+	return &timer{h, newRate(h.Count(), secs)}
+}
+
+// The shortest window.
+func (t *windowedTimer) view() metrics.Timer {
+	return t.snapshot(t.h.lengths[0])
+}
+
+func (t *windowedTimer) Count() int64                       { return t.view().Count() }
+func (t *windowedTimer) Max() int64                         { return t.view().Max() }
+func (t *windowedTimer) Mean() float64                      { return t.view().Mean() }
+func (t *windowedTimer) Min() int64                         { return t.view().Min() }
+func (t *windowedTimer) Percentile(p float64) float64       { return t.view().Percentile(p) }
+func (t *windowedTimer) Percentiles(ps []float64) []float64 { return t.view().Percentiles(ps) }
+func (t *windowedTimer) Rate1() float64                     { return t.view().Rate1() }
+func (t *windowedTimer) Rate5() float64                     { return t.view().Rate5() }
+func (t *windowedTimer) Rate15() float64                    { return t.view().Rate15() }
+func (t *windowedTimer) RateMean() float64                  { return t.view().RateMean() }
+func (t *windowedTimer) Snapshot() metrics.Timer            { return t.view() }
+func (t *windowedTimer) StdDev() float64                    { return t.view().StdDev() }
+func (t *windowedTimer) Stop()                              {}
+func (t *windowedTimer) Sum() int64                         { return t.view().Sum() }
+func (t *windowedTimer) Variance() float64                  { return t.view().Variance() }
+
+func (t *windowedTimer) Time(f func()) {
+	start := clock()
+	f()
+	t.UpdateSince(start)
+}
+
+func (t *windowedTimer) Update(d time.Duration) {
+	t.h.Update(int64(d))
+}
+
+func (t *windowedTimer) UpdateSince(start time.Time) {
+	t.Update(clock().Sub(start))
+}
+
+// A metrics.Meter over sliding windows.
+type windowedMeter struct {
+	sync.Mutex
+	ring
+	counts []int64
+}
+
+func newWindowedMeter(w *windowing) *windowedMeter {
+	m := &windowedMeter{ring: newRing(w)}
+	m.counts = make([]int64, m.size)
+	return m
+}
+
+func (m *windowedMeter) reset(i int) {
+	m.counts[i] = 0
+}
+
+func (m *windowedMeter) Mark(n int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.rotate(m.reset)
+	m.counts[m.cur] += n
+}
+
+func (m *windowedMeter) window(d time.Duration) interface{} {
+	return m.snapshot(d)
+}
+
+func (m *windowedMeter) snapshot(d time.Duration) metrics.Meter {
+	m.Lock()
+	defer m.Unlock()
+	m.rotate(m.reset)
+	slots, secs := m.span(d)
+	var count int64
+	for _, slot := range slots {
+		count += m.counts[slot]
+	}
+	return newRate(count, secs)
+}
+
+// The shortest window.
+func (m *windowedMeter) view() metrics.Meter {
+	return m.snapshot(m.lengths[0])
+}
+
+func (m *windowedMeter) Count() int64            { return m.view().Count() }
+func (m *windowedMeter) Rate1() float64          { return m.view().Rate1() }
+func (m *windowedMeter) Rate5() float64          { return m.view().Rate5() }
+func (m *windowedMeter) Rate15() float64         { return m.view().Rate15() }
+func (m *windowedMeter) RateMean() float64       { return m.view().RateMean() }
+func (m *windowedMeter) Snapshot() metrics.Meter { return m.view() }
+func (m *windowedMeter) Stop()                   {}
+
+// A read-only metrics.Meter for a window: every rate is the window's per-second average.
+type rate struct {
+	count int64
+	rate  float64
+}
+
+func newRate(count int64, secs float64) *rate {
+	r := &rate{count: count}
+	if secs > 0 {
+		r.rate = float64(count) / secs
+	}
+	return r
+}
+
+func (r *rate) Count() int64            { return r.count }
+func (r *rate) Mark(int64)              { panic("Mark called on a windowed meter's snapshot") }
+func (r *rate) Rate1() float64          { return r.rate }
+func (r *rate) Rate5() float64          { return r.rate }
+func (r *rate) Rate15() float64         { return r.rate }
+func (r *rate) RateMean() float64       { return r.rate }
+func (r *rate) Snapshot() metrics.Meter { return r }
+func (r *rate) Stop()                   {}
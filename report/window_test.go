@@ -0,0 +1,140 @@
+package report
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets the clock to start, returning a func to move it on.
+func fakeClock(t *testing.T, start time.Time) func(time.Duration) {
+	now := start
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+// Stats as served over HTTP and as sent to graphite, without graphite's timestamps.
+func exported(r *Recorder) (string, string) {
+	var http, graphite bytes.Buffer
+	writeStats(r, &http, true)
+	writeStats(r, &graphite, false)
+	return statLines(http.String()), statLines(graphite.String())
+}
+
+// Each line's name and value, sorted, as metrics are exported in no particular order.
+func statLines(s string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			lines = append(lines, fields[0]+" "+fields[1])
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestWindows(t *testing.T) {
+	advance := fakeClock(t, time.Unix(1200, 0))
+	r := NewRecorder().Windows(5*time.Minute, time.Minute, 90*time.Second)
+	assert.Equal(t, []time.Duration{time.Minute, 2 * time.Minute, 5 * time.Minute}, r.windows.lengths)
+	r.Prefix = "foobar"
+
+	for i := 1; i <= 60; i++ {
+		r.Time("baz", time.Duration(i)*time.Millisecond)
+		r.Inc("bar")
+	}
+	r.GetHistogram("size").Update(1000)
+
+	// nothing is reported until the first minute is complete.
+	http, graphite := exported(r)
+	assert.Equal(t, "", http)
+	assert.Equal(t, "", graphite)
+
+	advance(time.Minute + time.Second)
+	r.Time("baz", time.Hour)
+	http, graphite = exported(r)
+	assert.Equal(t, http, graphite, "HTTP and graphite are consistent")
+	stats := parseStats(http)
+	for _, w := range []string{"1m", "2m", "5m"} {
+		assert.Equal(t, 60.0, stats["foobar.baz."+w+".count"], w)
+		assert.Equal(t, 60.0, stats["foobar.baz."+w+".max"], w)
+		assert.InEpsilon(t, 30.0, stats["foobar.baz."+w+".percentiles.p50"], 0.01, w)
+		assert.Equal(t, 60.0, stats["foobar.bar."+w+".count"], w)
+		assert.Equal(t, 1.0, stats["foobar.bar."+w+".one-minute"], w)
+		assert.Equal(t, 1.0, stats["foobar.size."+w+".count"], w)
+	}
+	again, _ := exported(r)
+	assert.Equal(t, http, again, "exporting clears nothing")
+	assert.Equal(t, int64(60), r.GetTimer("baz").Count(), "the shortest window")
+
+	advance(time.Minute)
+	http, _ = exported(r)
+	stats = parseStats(http)
+	assert.Equal(t, 1.0, stats["foobar.baz.1m.count"])
+	assert.Equal(t, 3600000.0, stats["foobar.baz.1m.max"])
+	assert.Equal(t, 0.0, stats["foobar.bar.1m.count"])
+	assert.Equal(t, 61.0, stats["foobar.baz.2m.count"])
+	assert.Equal(t, 60.0, stats["foobar.bar.5m.count"])
+	// the meter has only existed for two minutes of its five minute window.
+	assert.Equal(t, 0.5, stats["foobar.bar.5m.one-minute"])
+
+	advance(10 * time.Minute)
+	http, graphite = exported(r)
+	assert.Equal(t, "", http)
+	assert.Equal(t, "", graphite)
+}
+
+func TestWindowedTimerClock(t *testing.T) {
+	advance := fakeClock(t, time.Unix(1200, 0))
+	timer := NewRecorder().Windows(time.Minute).GetTimer("baz")
+	timer.Time(func() { advance(time.Second) })
+	start := clock()
+	advance(2 * time.Second)
+	timer.UpdateSince(start)
+	advance(time.Minute)
+	assert.Equal(t, int64(2), timer.Count())
+	assert.Equal(t, int64(time.Second), timer.Min())
+	assert.Equal(t, int64(2*time.Second), timer.Max())
+}
+
+func TestInvalidWindows(t *testing.T) {
+	r := NewRecorder().Windows(0, -time.Minute)
+	assert.Nil(t, r.windows, "no valid windows leaves metrics unwindowed")
+	r.Windows(0, time.Minute)
+	assert.Equal(t, []time.Duration{time.Minute}, r.windows.lengths)
+}
+
+func TestWindowedBackends(t *testing.T) {
+	advance := fakeClock(t, time.Unix(1200, 0))
+	r := NewRecorder().Windows(time.Minute, 2*time.Minute)
+	r.HistogramsFor("uniform", Uniform(100))
+	r.HistogramsFor("buckets", Buckets(10, 100))
+
+	for _, name := range []string{"uniform", "buckets", "hdr"} {
+		h := r.GetHistogram(name)
+		h.Update(5)
+		advance(time.Minute)
+		h.Update(50)
+		advance(time.Minute)
+		w := h.(windowed)
+		assert.Equal(t, int64(1), w.window(time.Minute).(Histogram).Count(), name)
+		merged := w.window(2 * time.Minute).(Histogram)
+		assert.Equal(t, int64(2), merged.Count(), name)
+		assert.Equal(t, int64(5), merged.Min(), name)
+		assert.Equal(t, int64(50), merged.Max(), name)
+		assert.Equal(t, 27.5, merged.Mean(), name)
+	}
+}
+
+func TestWindowLabel(t *testing.T) {
+	assert.Equal(t, "1m", windowLabel(time.Minute))
+	assert.Equal(t, "15m", windowLabel(15*time.Minute))
+	assert.Equal(t, "2h", windowLabel(2*time.Hour))
+	assert.Equal(t, "90s", windowLabel(90*time.Second))
+	assert.Equal(t, "0.5s", windowLabel(500*time.Millisecond))
+}